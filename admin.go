@@ -0,0 +1,122 @@
+package main
+
+import (
+	"crypto/subtle"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+type adminpage struct {
+	syncstatus
+	Version  string
+	Events   int
+	Warnings []string
+}
+
+func equal(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+func checkbasicauth(r *http.Request, user, password string) bool {
+	u, p, ok := r.BasicAuth()
+	return ok && equal(u, user) && equal(p, password)
+}
+
+func loadclientca(path string) (*x509.CertPool, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(buf) {
+		return nil, errors.New(path + ": no PEM certificates found")
+	}
+	return pool, nil
+}
+
+// checkclientcert accepts requests with a certificate the TLS handshake
+// verified against Admin.ClientCA.
+func checkclientcert(r *http.Request) bool {
+	return r.TLS != nil && len(r.TLS.VerifiedChains) > 0
+}
+
+func adminauth(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		a := conf().Admin
+		if a.Password == "" && a.ClientCA == "" {
+			http.NotFound(w, r)
+			return
+		}
+		if a.ClientCA != "" && !checkclientcert(r) {
+			http.Error(w, "client certificate required", http.StatusForbidden)
+			return
+		}
+		if a.Password != "" && !checkbasicauth(r, a.User, a.Password) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="gpnsched admin"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// withprotection guards the whole instance, e.g. while the schedule is still
+// a draft. The admin pages are left to adminauth, as a browser only sends
+// one set of credentials.
+func withprotection(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := conf().Protect
+		if p.Password == "" && p.Token == "" || strings.HasPrefix(r.URL.Path, "/admin/") {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if p.Password != "" && checkbasicauth(r, p.User, p.Password) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && p.Token != "" && equal(token, p.Token) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if p.Password != "" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="gpnsched"`)
+		}
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	})
+}
+
+func handleadmin(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		switch strings.TrimPrefix(r.URL.Path, "/admin/") {
+		case "refresh":
+			triggerrefresh()
+		case "toggle":
+			if !togglesource(r.FormValue("source")) {
+				http.Error(w, "unknown source", http.StatusBadRequest)
+				return
+			}
+			triggerrefresh()
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		http.Redirect(w, r, "/admin/", http.StatusSeeOther)
+		return
+	}
+
+	page := adminpage{syncstatus: currentsyncstatus()}
+	snap := current()
+	page.Version = snap.version
+	page.Events = len(snap.schedule)
+	for i := range snap.schedule {
+		e := &snap.schedule[i]
+		for _, warning := range e.Warnings() {
+			page.Warnings = append(page.Warnings, fmt.Sprintf("%s %s: %s", e.Start, e.Titlestring(), warning))
+		}
+	}
+	render(w, r, "admin.html", page)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// anomaly is a problem in the upstream data, reported to the schedule
+// maintainers on /admin/anomalies.
+type anomaly struct {
+	Source string `json:",omitempty"`
+	// Index is the position of the event in the source's list.
+	Index  int
+	Event  string `json:",omitempty"`
+	Kind   string
+	Detail string
+}
+
+type anomalyreport struct {
+	Generated time.Time
+	Counts    map[string]int
+	Anomalies []anomaly
+}
+
+var lastanomalies anomalyreport
+
+func (e *event) anomalies() (ret []anomaly) {
+	add := func(kind, format string, args ...any) {
+		ret = append(ret, anomaly{Event: e.Start + " " + e.Titlestring(), Kind: kind, Detail: fmt.Sprintf(format, args...)})
+	}
+	if e.Title == "" {
+		add("title", "empty title")
+	}
+	if e.Place == "" {
+		add("room", "no room")
+	}
+	if _, err := zone(e.Timezone); err != nil {
+		add("timezone", "%s", err)
+	}
+	if parsegpntime(e.Start, time.Time{}, e.Zone()).IsZero() {
+		add("timestamp", "unparsable start %q", e.Start)
+	}
+	if e.End != "" && parsegpntime(e.End, time.Time{}, e.Zone()).IsZero() {
+		add("timestamp", "unparsable end %q", e.End)
+	}
+	if e.Endtime().Before(e.Starttime()) {
+		add("timestamp", "ends before it starts")
+	}
+	return
+}
+
+// parseschedule decodes the events one by one, so that a single malformed
+// event is dropped and reported instead of failing the whole source.
+func parseschedule(buf []byte) (calendar, []anomaly, error) {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(buf, &raw); err != nil {
+		return nil, nil, err
+	}
+	events := calendar{}
+	var found []anomaly
+	for i, m := range raw {
+		var e event
+		if err := json.Unmarshal(m, &e); err != nil {
+			found = append(found, anomaly{Index: i, Kind: "dropped", Detail: err.Error()})
+			continue
+		}
+		var fields any
+		json.Unmarshal(m, &fields)
+		unknown := unknownfields("", fields, reflect.TypeOf(event{}))
+		sort.Strings(unknown)
+		for _, f := range unknown {
+			found = append(found, anomaly{Index: i, Event: e.Start + " " + e.Titlestring(), Kind: "field", Detail: fmt.Sprintf("unknown field %q", f)})
+		}
+		for _, a := range append(e.fixend(), e.checkduration()...) {
+			a.Index, a.Event = i, e.Start+" "+e.Titlestring()
+			found = append(found, a)
+		}
+		for _, a := range e.anomalies() {
+			a.Index = i
+			found = append(found, a)
+		}
+		events = append(events, e)
+	}
+	return events, found, nil
+}
+
+func setanomalies(found []anomaly) {
+	r := anomalyreport{Generated: time.Now(), Counts: map[string]int{}, Anomalies: found}
+	for _, a := range found {
+		r.Counts[a.Kind]++
+	}
+	sourcesmutex.Lock()
+	defer sourcesmutex.Unlock()
+	lastanomalies = r
+}
+
+func handleanomalies(w http.ResponseWriter, r *http.Request) {
+	sourcesmutex.Lock()
+	report := lastanomalies
+	sourcesmutex.Unlock()
+	if report.Anomalies == nil {
+		report.Anomalies = []anomaly{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(report)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func apievents(w http.ResponseWriter, r *http.Request) {
+	lang := r.URL.Query().Get("language")
+	track := r.URL.Query().Get("track")
+
+	events := calendar{}
+	for _, e := range current().schedule {
+		if (lang == "" || strings.EqualFold(e.Language, lang)) && (track == "" || e.Type == track) {
+			events = append(events, e)
+		}
+	}
+
+	writeevents(w, r, events)
+}
+
+type apievent struct {
+	event
+	StartTime time.Time
+	EndTime   time.Time
+	Room      roommetadata
+}
+
+func newapievent(e event, tz *time.Location) apievent {
+	return apievent{e, e.Starttime().In(tz), e.Endtime().In(tz), e.Place.Metadata()}
+}
+
+func requestlocation(r *http.Request) (*time.Location, error) {
+	tz := r.URL.Query().Get("tz")
+	if tz == "" {
+		return loc, nil
+	}
+	l, err := time.LoadLocation(tz)
+	if err != nil || tz == "Local" {
+		return nil, fmt.Errorf("unknown time zone %q", tz)
+	}
+	return l, nil
+}
+
+// windowevents applies the from, until, offset and limit parameters.
+func windowevents(r *http.Request, c calendar) (calendar, int, error) {
+	q := r.URL.Query()
+	var from, until time.Time
+	for _, p := range []struct {
+		name string
+		t    *time.Time
+	}{{"from", &from}, {"until", &until}} {
+		if v := q.Get(p.name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return nil, 0, fmt.Errorf("invalid %s: %w", p.name, err)
+			}
+			*p.t = t
+		}
+	}
+	var offset, limit int
+	for _, p := range []struct {
+		name string
+		n    *int
+	}{{"offset", &offset}, {"limit", &limit}} {
+		if v := q.Get(p.name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return nil, 0, fmt.Errorf("invalid %s %q", p.name, v)
+			}
+			*p.n = n
+		}
+	}
+
+	ret := calendar{}
+	for _, e := range c {
+		if !from.IsZero() && !e.Endtime().After(from) || !until.IsZero() && !e.Starttime().Before(until) {
+			continue
+		}
+		ret = append(ret, e)
+	}
+	total := len(ret)
+	ret = ret[min(offset, total):]
+	if limit > 0 && limit < len(ret) {
+		ret = ret[:limit]
+	}
+	return ret, total, nil
+}
+
+func writeevents(w http.ResponseWriter, r *http.Request, c calendar) {
+	tz, err := requestlocation(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c, total, err := windowevents(r, c)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	events := []apievent{}
+	for _, e := range c {
+		events = append(events, newapievent(e, tz))
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	json.NewEncoder(w).Encode(events)
+}
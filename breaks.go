@@ -0,0 +1,51 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+func gpntime(t time.Time) string {
+	return t.In(loc).Format("20060102-1504")
+}
+
+func addbreaks(events calendar) calendar {
+	if conf().BreakMinGap <= 0 {
+		return events
+	}
+	mingap := time.Duration(conf().BreakMinGap) * time.Minute
+	maxgap := time.Duration(conf().BreakMaxGap) * time.Minute
+	lunchgap := time.Duration(conf().LunchMinGap) * time.Minute
+
+	rooms := map[location]calendar{}
+	for _, e := range events {
+		if e.Place != "" {
+			rooms[e.Place] = append(rooms[e.Place], e)
+		}
+	}
+
+	for room, evs := range rooms {
+		sort.Slice(evs, func(i, j int) bool {
+			return evs[i].Starttime().Before(evs[j].Starttime())
+		})
+		for i := 1; i < len(evs); i++ {
+			from, to := evs[i-1].Endtime(), evs[i].Starttime()
+			gap := to.Sub(from)
+			if gap < mingap || maxgap > 0 && gap > maxgap {
+				continue
+			}
+			title := translate(conf().Language, "break")
+			if h := from.In(loc).Hour(); lunchgap > 0 && gap >= lunchgap && h >= 11 && h < 15 {
+				title = translate(conf().Language, "lunch")
+			}
+			events = append(events, event{
+				Start: gpntime(from),
+				End:   gpntime(to),
+				Type:  "break",
+				Title: title,
+				Place: room,
+			})
+		}
+	}
+	return events
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// maxpooledbuffer keeps a single huge render from being held on to forever.
+const maxpooledbuffer = 8 << 20
+
+// Rendering happens on every sync and for every calendar requested with
+// options, so the buffers and line folding state are recycled instead of
+// being grown from scratch each time.
+var (
+	bufferpool     = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+	linewriterpool = sync.Pool{New: func() any { return new(BreakLongLineWriter) }}
+)
+
+func getbuffer() *bytes.Buffer {
+	buf := bufferpool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+func putbuffer(buf *bytes.Buffer) {
+	if buf.Cap() <= maxpooledbuffer {
+		bufferpool.Put(buf)
+	}
+}
+
+func getlinewriter(w io.Writer, linelength int) *BreakLongLineWriter {
+	b := linewriterpool.Get().(*BreakLongLineWriter)
+	b.w, b.maxlen, b.pos = w, linelength, 0
+	return b
+}
+
+func putlinewriter(b *BreakLongLineWriter) {
+	b.w = nil
+	if b.line.Cap() <= maxpooledbuffer {
+		linewriterpool.Put(b)
+	}
+}
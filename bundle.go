@@ -0,0 +1,45 @@
+package main
+
+import (
+	"archive/zip"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+)
+
+// writebundle writes every public calendar, including the combined one, as
+// <slug>.ics into a zip archive.
+func writebundle(w io.Writer, snap *snapshot) error {
+	var rooms []location
+	for l := range snap.icals {
+		rooms = append(rooms, l)
+	}
+	sort.Slice(rooms, func(i, j int) bool { return rooms[i].Slug() < rooms[j].Slug() })
+
+	z := zip.NewWriter(w)
+	for _, l := range rooms {
+		f, err := z.CreateHeader(&zip.FileHeader{Name: l.Slug() + ".ics", Method: zip.Deflate, Modified: snap.modtime()})
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(snap.icals[l]); err != nil {
+			return err
+		}
+	}
+	return z.Close()
+}
+
+func handlebundle(w http.ResponseWriter, r *http.Request) {
+	snap := current()
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="gpn-calendars.zip"`)
+	w.Header().Set("ETag", `"`+snap.version+`"`)
+	if r.Method == http.MethodHead {
+		return
+	}
+	// the archive is streamed, so a failure can only cut it short
+	if err := writebundle(newflushwriter(w, r), snap); err != nil {
+		log.Println("writing calendar bundle:", err)
+	}
+}
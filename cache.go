@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+)
+
+var fallbackschedule []byte
+
+func publishfallback() error {
+	events := calendar{}
+	if err := json.Unmarshal(fallbackschedule, &events); err != nil {
+		return err
+	}
+	return publish(events)
+}
+
+func loadcache() (calendar, error) {
+	if conf().Cache == "" {
+		return nil, nil
+	}
+	events, err := fetchschedule(conf().Cache)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return events, err
+}
+
+func savecache(events calendar) error {
+	if conf().Cache == "" {
+		return nil
+	}
+	buf, err := json.Marshal(events)
+	if err != nil {
+		return err
+	}
+	tmp := conf().Cache + ".tmp"
+	if err := os.WriteFile(tmp, buf, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, conf().Cache)
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+const caldavprefix = "/caldav/"
+
+type davresponse struct {
+	href  string
+	props string
+}
+
+func xmlescape(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+func (e *event) ETag() string {
+	buf, _ := json.Marshal(e)
+	sum := sha256.Sum256(append(buf, e.Feedback()...))
+	return `"` + hex.EncodeToString(sum[:8]) + `"`
+}
+
+// Version doesn't depend on the order of the events, just like the
+// rendered calendars.
+func (c calendar) Version() string {
+	etags := make([]string, len(c))
+	for i := range c {
+		etags[i] = c[i].ETag()
+	}
+	slices.Sort(etags)
+	hash := sha256.New()
+	for _, etag := range etags {
+		io.WriteString(hash, etag)
+	}
+	return hex.EncodeToString(hash.Sum(nil)[:8])
+}
+
+func (c calendar) ETag() string {
+	return `"` + c.Version() + `"`
+}
+
+func (c calendar) Room(l location) (ret calendar) {
+	for _, e := range c {
+		if l == "Alle" || e.Place == l {
+			ret = append(ret, e)
+		}
+	}
+	return
+}
+
+func davhref(l location, uid string) string {
+	href := caldavprefix + url.PathEscape(l.String()) + "/"
+	if uid != "" {
+		href += uid + ".ics"
+	}
+	return href
+}
+
+func writemultistatus(w http.ResponseWriter, responses []davresponse) {
+	w.Header().Set("Content-Type", `application/xml; charset="utf-8"`)
+	w.WriteHeader(207)
+	io.WriteString(w, `<?xml version="1.0" encoding="utf-8"?>`+"\n")
+	io.WriteString(w, `<d:multistatus xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav" xmlns:cs="http://calendarserver.org/ns/">`+"\n")
+	for _, r := range responses {
+		fmt.Fprintf(w, "<d:response><d:href>%s</d:href><d:propstat><d:prop>%s</d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response>\n", xmlescape(r.href), r.props)
+	}
+	io.WriteString(w, "</d:multistatus>\n")
+}
+
+func davhome(href string) davresponse {
+	return davresponse{href, "<d:resourcetype><d:collection/></d:resourcetype>" +
+		"<d:displayname>gpnsched</d:displayname>" +
+		"<d:current-user-principal><d:href>" + caldavprefix + "</d:href></d:current-user-principal>" +
+		"<d:principal-URL><d:href>" + caldavprefix + "</d:href></d:principal-URL>" +
+		"<c:calendar-home-set><d:href>" + caldavprefix + "</d:href></c:calendar-home-set>"}
+}
+
+func davcollection(l location, c calendar) davresponse {
+	return davresponse{davhref(l, ""), "<d:resourcetype><d:collection/><c:calendar/></d:resourcetype>" +
+		"<d:displayname>" + xmlescape(l.DisplayName()) + "</d:displayname>" +
+		"<c:supported-calendar-component-set><c:comp name=\"VEVENT\"/></c:supported-calendar-component-set>" +
+		"<d:current-user-privilege-set><d:privilege><d:read/></d:privilege></d:current-user-privilege-set>" +
+		"<cs:getctag>" + xmlescape(c.ETag()) + "</cs:getctag>" +
+		"<d:sync-token>" + xmlescape(c.ETag()) + "</d:sync-token>"}
+}
+
+func davevent(l location, e *event, data bool) davresponse {
+	props := "<d:resourcetype/>" +
+		"<d:getcontenttype>text/calendar; component=vevent</d:getcontenttype>" +
+		"<d:getetag>" + xmlescape(e.ETag()) + "</d:getetag>"
+	if data {
+		props += "<c:calendar-data>" + xmlescape(string(calendar{*e}.ICal())) + "</c:calendar-data>"
+	}
+	return davresponse{davhref(l, e.UID()), props}
+}
+
+type davreport struct {
+	hrefs      []string
+	start, end time.Time
+}
+
+func parsedavreport(r io.Reader) (ret davreport) {
+	dec := xml.NewDecoder(r)
+	inhref := false
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			inhref = t.Name.Local == "href"
+			if t.Name.Local == "time-range" {
+				for _, a := range t.Attr {
+					v, err := time.Parse("20060102T150405Z", a.Value)
+					if err != nil {
+						continue
+					}
+					switch a.Name.Local {
+					case "start":
+						ret.start = v
+					case "end":
+						ret.end = v
+					}
+				}
+			}
+		case xml.CharData:
+			if inhref {
+				ret.hrefs = append(ret.hrefs, strings.TrimSpace(string(t)))
+			}
+		case xml.EndElement:
+			inhref = false
+		}
+	}
+}
+
+func (q davreport) matches(e *event) bool {
+	if len(q.hrefs) > 0 {
+		for _, h := range q.hrefs {
+			if u, err := url.PathUnescape(h); err == nil && strings.HasSuffix(u, "/"+e.UID()+".ics") {
+				return true
+			}
+		}
+		return false
+	}
+	if !q.start.IsZero() && !e.Endtime().After(q.start) {
+		return false
+	}
+	if !q.end.IsZero() && !e.Starttime().Before(q.end) {
+		return false
+	}
+	return true
+}
+
+// handlewellknowncaldav points autodiscovering clients (RFC 6764) at the
+// calendar home, so they can be set up with just the host name.
+func handlewellknowncaldav(w http.ResponseWriter, r *http.Request) {
+	http.Redirect(w, r, caldavprefix, http.StatusMovedPermanently)
+}
+
+// handledavroot answers clients that skip .well-known and look for the
+// principal on the root.
+func handledavroot(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("DAV", "1, calendar-access")
+	writemultistatus(w, []davresponse{davhome("/")})
+}
+
+func handlecaldav(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("DAV", "1, calendar-access")
+	if r.Method == "OPTIONS" {
+		w.Header().Set("Allow", "OPTIONS, GET, HEAD, PROPFIND, REPORT")
+		return
+	}
+
+	room, uid, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, caldavprefix), "/")
+	uid = strings.TrimSuffix(uid, ".ics")
+	l := location(room)
+
+	snap := current()
+	_, known := snap.icals[l]
+	c := snap.schedule.Room(l)
+
+	if room != "" && !known {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch r.Method {
+	case "GET", "HEAD":
+		if uid == "" {
+			w.Header().Set("Content-Type", "text/calendar")
+			w.Write(c.ICal())
+			return
+		}
+		e := c.Event(uid)
+		if e == nil {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/calendar")
+		w.Header().Set("ETag", e.ETag())
+		w.Write(calendar{*e}.ICal())
+	case "PROPFIND":
+		depth := r.Header.Get("Depth")
+		var responses []davresponse
+		switch {
+		case room == "":
+			responses = append(responses, davhome(caldavprefix))
+			if depth != "0" {
+				var rooms []location
+				for l := range snap.icals {
+					rooms = append(rooms, l)
+				}
+				sort.Slice(rooms, func(i, j int) bool { return rooms[i] < rooms[j] })
+				for _, l := range rooms {
+					responses = append(responses, davcollection(l, snap.schedule.Room(l)))
+				}
+			}
+		case uid == "":
+			responses = append(responses, davcollection(l, c))
+			if depth != "0" {
+				for i := range c {
+					responses = append(responses, davevent(l, &c[i], false))
+				}
+			}
+		default:
+			e := c.Event(uid)
+			if e == nil {
+				http.NotFound(w, r)
+				return
+			}
+			responses = append(responses, davevent(l, e, false))
+		}
+		writemultistatus(w, responses)
+	case "REPORT":
+		if room == "" {
+			http.Error(w, "REPORT needs a calendar collection", http.StatusBadRequest)
+			return
+		}
+		q := parsedavreport(r.Body)
+		var responses []davresponse
+		for i := range c {
+			if q.matches(&c[i]) {
+				responses = append(responses, davevent(l, &c[i], true))
+			}
+		}
+		writemultistatus(w, responses)
+	default:
+		w.Header().Set("Allow", "OPTIONS, GET, HEAD, PROPFIND, REPORT")
+		http.Error(w, "read-only calendar", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var caldavresource = regexp.MustCompile(`^[0-9a-f]{64}\.ics$`)
+
+type caldavstate struct {
+	hash string
+	etag string
+}
+
+type caldavpusher struct {
+	url, user, password string
+	client              *http.Client
+
+	pushed  map[string]caldavstate
+	initial bool
+}
+
+func newcaldavpusher(collection, user, password string) *caldavpusher {
+	return &caldavpusher{
+		url:      strings.TrimSuffix(collection, "/") + "/",
+		user:     user,
+		password: password,
+		client:   &http.Client{Timeout: 30 * time.Second},
+		pushed:   map[string]caldavstate{},
+		initial:  true,
+	}
+}
+
+func (p *caldavpusher) request(method, uid string, body []byte, header http.Header) (*http.Response, error) {
+	target := p.url
+	if uid != "" {
+		target += uid + ".ics"
+	}
+	req, err := http.NewRequest(method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if p.user != "" {
+		req.SetBasicAuth(p.user, p.password)
+	}
+	return p.client.Do(req)
+}
+
+func (p *caldavpusher) load() error {
+	resp, err := p.request("PROPFIND", "", []byte(`<?xml version="1.0" encoding="utf-8"?><d:propfind xmlns:d="DAV:"><d:prop><d:getetag/></d:prop></d:propfind>`),
+		http.Header{"Depth": {"1"}, "Content-Type": {`application/xml; charset="utf-8"`}})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 207 {
+		return fmt.Errorf("caldav: PROPFIND %s: %s", p.url, resp.Status)
+	}
+
+	var ms struct {
+		Responses []struct {
+			Href string `xml:"href"`
+			ETag string `xml:"propstat>prop>getetag"`
+		} `xml:"response"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return err
+	}
+	for _, r := range ms.Responses {
+		href, err := url.PathUnescape(r.Href)
+		if err != nil {
+			continue
+		}
+		if name := path.Base(href); caldavresource.MatchString(name) {
+			p.pushed[strings.TrimSuffix(name, ".ics")] = caldavstate{etag: r.ETag}
+		}
+	}
+	return nil
+}
+
+func (p *caldavpusher) put(e *event) error {
+	uid := e.UID()
+	state := p.pushed[uid]
+	body := calendar{*e}.ICal()
+
+	for retry := 0; retry < 2; retry++ {
+		header := http.Header{"Content-Type": {"text/calendar; charset=utf-8"}}
+		if state.etag != "" {
+			header.Set("If-Match", state.etag)
+		} else {
+			header.Set("If-None-Match", "*")
+		}
+		resp, err := p.request("PUT", uid, body, header)
+		if err != nil {
+			return err
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		switch {
+		case resp.StatusCode < 300:
+			p.pushed[uid] = caldavstate{hash: e.ETag(), etag: resp.Header.Get("ETag")}
+			return nil
+		case resp.StatusCode == http.StatusPreconditionFailed:
+			head, err := p.request("HEAD", uid, nil, nil)
+			if err != nil {
+				return err
+			}
+			head.Body.Close()
+			state.etag = ""
+			if head.StatusCode < 300 {
+				state.etag = head.Header.Get("ETag")
+			}
+		default:
+			return fmt.Errorf("caldav: PUT %s: %s", uid, resp.Status)
+		}
+	}
+	return fmt.Errorf("caldav: PUT %s: precondition failed", uid)
+}
+
+func (p *caldavpusher) remove(uid string) error {
+	header := http.Header{}
+	if etag := p.pushed[uid].etag; etag != "" {
+		header.Set("If-Match", etag)
+	}
+	resp, err := p.request("DELETE", uid, nil, header)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("caldav: DELETE %s: %s", uid, resp.Status)
+	}
+	delete(p.pushed, uid)
+	return nil
+}
+
+func (p *caldavpusher) push(c calendar) {
+	if p.initial {
+		if err := p.load(); err != nil {
+			log.Println(err)
+			return
+		}
+		p.initial = false
+	}
+
+	current := map[string]bool{}
+	for i := range c {
+		e := &c[i]
+		current[e.UID()] = true
+		if p.pushed[e.UID()].hash == e.ETag() {
+			continue
+		}
+		if err := p.put(e); err != nil {
+			log.Println(err)
+		}
+	}
+	for uid := range p.pushed {
+		if !current[uid] {
+			if err := p.remove(uid); err != nil {
+				log.Println(err)
+			}
+		}
+	}
+}
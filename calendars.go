@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+type calendarfeed struct {
+	Kind   string
+	Name   string
+	URL    string
+	Webcal string
+	Events int
+}
+
+// calendarfeeds lists every calendar a client can subscribe to, so apps can
+// offer their own picker instead of scraping the index page.
+func calendarfeeds(r *http.Request, snap *snapshot) []calendarfeed {
+	ret := []calendarfeed{}
+	add := func(kind, name, path string, events int) {
+		ret = append(ret, calendarfeed{
+			Kind:   kind,
+			Name:   name,
+			URL:    baseurl(r) + path,
+			Webcal: "webcal://" + r.Host + path,
+			Events: events,
+		})
+	}
+	path := func(kind, name string) string {
+		p, _ := feedpath(kind, name)
+		return p
+	}
+	for _, s := range subscriptions(r, snap) {
+		add("room", s.Name.DisplayName(), roompath(s.Name), len(snap.schedule.Room(s.Name)))
+	}
+	for _, t := range tracks(snap.schedule) {
+		add("track", t.Name, path("track", t.ID), len(t.Events))
+	}
+	for _, s := range speakers(snap.schedule) {
+		add("speaker", s.Name, path("speaker", s.Name), len(s.Talks))
+	}
+	for _, day := range conferencedays(snap.schedule) {
+		name := formattime(requestlanguage(r), day, "Monday, 02.01.")
+		add("day", name, path("day", day.Format("2006-01-02")), len(snap.schedule.Day(day)))
+	}
+	return ret
+}
+
+func handlecalendars(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(calendarfeeds(r, current()))
+}
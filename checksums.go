@@ -0,0 +1,38 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// checksums lists the SHA-256 of every published file in the format of
+// sha256sum, with paths relative to the site root, so that a mirror can
+// check which files changed or run sha256sum -c.
+func checksums(icals map[location][]byte, signatures map[location][]byte, timetable []byte) []byte {
+	files := map[string][]byte{}
+	for l, ical := range icals {
+		files[strings.TrimPrefix(roompath(l), "/")] = ical
+		if sig, ok := signatures[l]; ok {
+			files[strings.TrimPrefix(roompath(l), "/")+".asc"] = sig
+		}
+	}
+	if timetable != nil {
+		files["schedule.pdf"] = timetable
+	}
+	var b strings.Builder
+	for _, path := range slices.Sorted(maps.Keys(files)) {
+		fmt.Fprintf(&b, "%x  %s\n", sha256.Sum256(files[path]), path)
+	}
+	return []byte(b.String())
+}
+
+func handlechecksums(w http.ResponseWriter, r *http.Request) {
+	snap := current()
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("ETag", `"`+snap.version+`"`)
+	w.Write(snap.checksums)
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sync/atomic"
+)
+
+type typeproperties struct {
+	Transp   string
+	Class    string
+	Priority int
+}
+
+type conference struct {
+	Acronym string
+	Title   string
+}
+
+type config struct {
+	Listen    string
+	TLS       tlsconfig
+	Listeners []listener
+	H2C       bool
+	Source    string
+	Sources   []source
+	Merge     map[string]string
+	Cache     string
+
+	Overrides string
+	Feeds     string
+	// Subscribers is where the daily calendar subscriber counts are kept,
+	// for SubscriberDays days.
+	Subscribers    string
+	SubscriberDays int
+	// Feedback is the URL template of the feedback page linked from talks
+	// that are over, with {uid}, {slug} and {title} replaced per talk.
+	Feedback string
+	Allow    eventfilter
+	Deny     eventfilter
+
+	Interval     float64
+	LiveInterval float64
+	IdleInterval float64
+	Jitter       float64
+
+	StaleIntervals float64
+	Language       string
+
+	C3navURL string
+	C3nav    map[location]string
+	Rooms    map[location]roommetadata
+	Private  map[location]string
+
+	DayChange int
+
+	BreakMinGap int
+	BreakMaxGap int
+	LunchMinGap int
+
+	Strict          bool
+	Duration        bool
+	DefaultDuration float64
+	MinDuration     float64
+	MaxDuration     float64
+	ClampDurations  bool
+	Times           string
+	Recurrence      bool
+	Types           map[string]typeproperties
+	Tracks          map[string]trackmetadata
+
+	SeriesPattern string
+
+	Google struct {
+		Credentials string
+		Calendar    string
+	}
+
+	CalDAVPush struct {
+		URL      string
+		User     string
+		Password string
+	}
+
+	NotifyLead float64
+
+	XMPP struct {
+		JID      string
+		Password string
+		Server   string
+		Nick     string
+		Rooms    []string
+	}
+
+	// Engelsystem is the base URL of the volunteer planning instance whose
+	// shifts are merged into /angels/<key>.ics.
+	Engelsystem struct {
+		URL string
+	}
+
+	Telegram struct {
+		Token       string
+		Subscribers string
+	}
+
+	Conference conference
+
+	Admin struct {
+		User     string
+		Password string
+		ClientCA string
+	}
+
+	Sign struct {
+		Key  string
+		Home string
+	}
+
+	Protect struct {
+		User     string
+		Password string
+		Token    string
+	}
+
+	// BaseURL is the public URL of this instance, for links that are
+	// rendered outside of a request, like images in calendars.
+	BaseURL string
+	Images  string
+
+	Templates      string
+	TemplateReload int
+	ConfigReload   int
+
+	// RenderWorkers bounds how many calendars are rendered at once during a
+	// sync, by default one per CPU.
+	RenderWorkers int
+}
+
+func defaultconfig() config {
+	return config{
+		Listen:          ":8000",
+		ConfigReload:    10,
+		SubscriberDays:  30,
+		DefaultDuration: 60,
+		MinDuration:     1,
+		MaxDuration:     12 * 60,
+		Source:          "http://bl0rg.net/~andi/gpn13-fahrplan.json",
+		Language:        "en",
+
+		Conference: conference{Acronym: "gpn13", Title: "GPN13"},
+
+		SeriesPattern: `(?i)\s*[(\[]?\s*(part|teil)\s*\d+(\s*(/|of|von)\s*\d+)?\s*[)\]]?\s*$`,
+
+		Interval:     5,
+		LiveInterval: 2,
+		IdleInterval: 60,
+		Jitter:       0.1,
+
+		StaleIntervals: 3,
+
+		C3nav: map[location]string{},
+		Types: map[string]typeproperties{
+			"break": {Transp: "TRANSPARENT"},
+		},
+	}
+}
+
+// currentconf is replaced as a whole when the config file is reloaded, so
+// readers see either the old or the new config, never a mix.
+var currentconf = func() *atomic.Pointer[config] {
+	p := &atomic.Pointer[config]{}
+	c := defaultconfig()
+	p.Store(&c)
+	return p
+}()
+
+func conf() *config {
+	return currentconf.Load()
+}
+
+func setconf(c config) {
+	currentconf.Store(&c)
+}
+
+func readconfig(path string) error {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	c, err := decodeconfig(path, buf, defaultconfig())
+	if err != nil {
+		return err
+	}
+	setconf(c)
+	return nil
+}
+
+func configflags(name string) (*flag.FlagSet, *string) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	return fs, fs.String("config", "", "json config file")
+}
+
+func parseflags(fs *flag.FlagSet, configfile *string, args []string) {
+	fs.Parse(args)
+	if *configfile != "" {
+		configpath = *configfile
+		if err := readconfig(*configfile); err != nil {
+			fmt.Fprintln(os.Stderr, "invalid config:")
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+}
@@ -0,0 +1,400 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+type configproblem struct {
+	file string
+	line int
+	path string
+	msg  string
+}
+
+func (p configproblem) Error() string {
+	where := p.file
+	if p.line > 0 {
+		where += fmt.Sprintf(":%d", p.line)
+	}
+	if p.path != "" {
+		return fmt.Sprintf("%s: %s: %s", where, p.path, p.msg)
+	}
+	return fmt.Sprintf("%s: %s", where, p.msg)
+}
+
+// configlines maps JSON paths like Sources[0].URL to the line they start on.
+func configlines(buf []byte) map[string]int {
+	ret := map[string]int{}
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	line := func() int {
+		off := int(dec.InputOffset())
+		for off < len(buf) && strings.IndexByte(" \t\r\n,", buf[off]) >= 0 {
+			off++
+		}
+		return 1 + bytes.Count(buf[:off], []byte{'\n'})
+	}
+	var walk func(path string) error
+	walk = func(path string) error {
+		t, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch t {
+		case json.Delim('{'):
+			for dec.More() {
+				k, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				p := k.(string)
+				if path != "" {
+					p = path + "." + p
+				}
+				ret[p] = line()
+				if err := walk(p); err != nil {
+					return err
+				}
+			}
+			_, err = dec.Token()
+		case json.Delim('['):
+			for i := 0; dec.More(); i++ {
+				p := fmt.Sprintf("%s[%d]", path, i)
+				ret[p] = line()
+				if err := walk(p); err != nil {
+					return err
+				}
+			}
+			_, err = dec.Token()
+		}
+		return err
+	}
+	walk("")
+	return ret
+}
+
+func jsonfield(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "" {
+			name = f.Name
+		}
+		if f.IsExported() && strings.EqualFold(name, key) {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// unknownfields reports keys in v that encoding/json would silently ignore
+// when decoding into t.
+func unknownfields(path string, v any, t reflect.Type) (ret []string) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	join := func(k string) string {
+		if path == "" {
+			return k
+		}
+		return path + "." + k
+	}
+	switch v := v.(type) {
+	case map[string]any:
+		switch t.Kind() {
+		case reflect.Struct:
+			for k, val := range v {
+				f, ok := jsonfield(t, k)
+				if !ok {
+					ret = append(ret, join(k))
+					continue
+				}
+				ret = append(ret, unknownfields(join(k), val, f.Type)...)
+			}
+		case reflect.Map:
+			for k, val := range v {
+				ret = append(ret, unknownfields(join(k), val, t.Elem())...)
+			}
+		}
+	case []any:
+		if t.Kind() == reflect.Slice {
+			for i, val := range v {
+				ret = append(ret, unknownfields(fmt.Sprintf("%s[%d]", path, i), val, t.Elem())...)
+			}
+		}
+	}
+	return
+}
+
+func checkurl(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("%q is not an http(s) URL", s)
+	}
+	return nil
+}
+
+func checksource(s string) error {
+	if strings.Contains(s, "://") {
+		return checkurl(s)
+	}
+	_, err := os.Stat(s)
+	return err
+}
+
+// checkconfig validates c, which was decoded from buf, and returns all
+// problems found.
+func checkconfig(file string, buf []byte, c *config) []error {
+	lines := configlines(buf)
+	var problems []error
+	add := func(path, format string, args ...any) {
+		line := lines[path]
+		for p := path; line == 0 && p != ""; {
+			i := strings.LastIndexAny(p, ".[")
+			if i < 0 {
+				break
+			}
+			p = p[:i]
+			line = lines[p]
+		}
+		problems = append(problems, configproblem{file, line, path, fmt.Sprintf(format, args...)})
+	}
+
+	var raw any
+	if json.Unmarshal(buf, &raw) == nil {
+		unknown := unknownfields("", raw, reflect.TypeOf(config{}))
+		sort.Strings(unknown)
+		for _, path := range unknown {
+			add(path, "unknown setting")
+		}
+	}
+
+	if _, _, err := net.SplitHostPort(c.Listen); err != nil {
+		add("Listen", "%s", err)
+	}
+	if (c.TLS.Cert == "") != (c.TLS.Key == "") {
+		add("TLS", "Cert and Key must be set together")
+	}
+	for i, l := range c.Listeners {
+		path := fmt.Sprintf("Listeners[%d]", i)
+		if _, _, err := net.SplitHostPort(l.Addr); err != nil {
+			add(path+".Addr", "%s", err)
+		}
+		if (l.TLS.Cert == "") != (l.TLS.Key == "") {
+			add(path+".TLS", "Cert and Key must be set together")
+		}
+		if !slices.Contains(listenerkinds, l.Serve) {
+			add(path+".Serve", "must be one of all, public, admin or redirect")
+		}
+		if l.Serve == "redirect" {
+			if err := checkurl(l.Redirect); err != nil {
+				add(path+".Redirect", "%s", err)
+			}
+		}
+	}
+	if len(c.Sources) == 0 {
+		if err := checksource(c.Source); err != nil {
+			add("Source", "%s", err)
+		}
+	}
+	names := map[string]bool{}
+	for i, s := range c.Sources {
+		path := fmt.Sprintf("Sources[%d]", i)
+		if s.Name == "" {
+			add(path+".Name", "sources need a name")
+		} else if names[s.Name] {
+			add(path+".Name", "duplicate source %q", s.Name)
+		}
+		names[s.Name] = true
+		if err := checksource(s.URL); err != nil {
+			add(path+".URL", "%s", err)
+		}
+	}
+	for field, src := range c.Merge {
+		if _, ok := reflect.TypeOf(event{}).FieldByName(field); !ok {
+			add("Merge."+field, "events have no field %q", field)
+		}
+		if len(c.Sources) > 0 && !names[src] {
+			add("Merge."+field, "unknown source %q", src)
+		}
+	}
+
+	if c.DayChange < 0 || c.DayChange > 12 {
+		add("DayChange", "must be an hour between 0 and 12")
+	}
+	if c.MinDuration < 0 || c.MaxDuration < 0 {
+		add("MinDuration", "duration limits can't be negative")
+	} else if c.MaxDuration > 0 && c.MinDuration > c.MaxDuration {
+		add("MinDuration", "larger than MaxDuration")
+	}
+	for path, v := range map[string]float64{"Interval": c.Interval, "LiveInterval": c.LiveInterval, "IdleInterval": c.IdleInterval, "DefaultDuration": c.DefaultDuration} {
+		if v <= 0 {
+			add(path, "must be a positive number of minutes")
+		}
+	}
+	if c.Jitter < 0 || c.Jitter >= 1 {
+		add("Jitter", "must be between 0 and 1")
+	}
+	if c.StaleIntervals < 0 {
+		add("StaleIntervals", "must not be negative")
+	}
+	if catalogs[c.Language] == nil {
+		add("Language", "no translations for %q", c.Language)
+	}
+	switch c.Times {
+	case "", icaltimesutc, icaltimesfloating, icaltimestzid:
+	default:
+		add("Times", "must be one of %s, %s or %s", icaltimesutc, icaltimesfloating, icaltimestzid)
+	}
+	if _, err := regexp.Compile(c.SeriesPattern); err != nil {
+		add("SeriesPattern", "%s", err)
+	}
+	if c.C3navURL != "" {
+		if err := checkurl(c.C3navURL); err != nil {
+			add("C3navURL", "%s", err)
+		}
+	}
+	for room, m := range c.Rooms {
+		if m.Stream != "" {
+			if err := checkurl(m.Stream); err != nil {
+				add("Rooms."+string(room)+".Stream", "%s", err)
+			}
+		}
+	}
+	for room, token := range c.Private {
+		if token == "" {
+			add("Private."+string(room), "empty token")
+		}
+	}
+	if c.Admin.Password != "" && c.Admin.User == "" {
+		add("Admin.User", "must be set with Admin.Password")
+	}
+	if c.Admin.ClientCA != "" {
+		if _, err := loadclientca(c.Admin.ClientCA); err != nil {
+			add("Admin.ClientCA", "%s", err)
+		}
+		if c.TLS.Cert == "" && !slices.ContainsFunc(c.Listeners, func(l listener) bool { return l.TLS.Cert != "" }) {
+			add("Admin.ClientCA", "needs a listener with TLS")
+		}
+	}
+	if c.BaseURL != "" {
+		if err := checkurl(c.BaseURL); err != nil {
+			add("BaseURL", "%s", err)
+		}
+	}
+	if c.Feedback != "" {
+		if err := checkurl(strings.NewReplacer("{uid}", "x", "{slug}", "x", "{title}", "x").Replace(c.Feedback)); err != nil {
+			add("Feedback", "%s", err)
+		}
+	}
+	if c.Engelsystem.URL != "" {
+		if err := checkurl(c.Engelsystem.URL); err != nil {
+			add("Engelsystem.URL", "%s", err)
+		}
+	}
+	if c.Sign.Key != "" {
+		if _, err := exec.LookPath("gpg"); err != nil {
+			add("Sign.Key", "signing needs gpg: %s", err)
+		}
+	}
+	if c.Protect.Password != "" && c.Protect.User == "" {
+		add("Protect.User", "must be set with Protect.Password")
+	}
+	if c.CalDAVPush.URL != "" {
+		if err := checkurl(c.CalDAVPush.URL); err != nil {
+			add("CalDAVPush.URL", "%s", err)
+		}
+	}
+	if c.XMPP.JID != "" && !strings.Contains(c.XMPP.JID, "@") {
+		add("XMPP.JID", "%q is not a JID", c.XMPP.JID)
+	}
+	if c.Templates != "" {
+		if fi, err := os.Stat(c.Templates); err != nil {
+			add("Templates", "%s", err)
+		} else if !fi.IsDir() {
+			add("Templates", "not a directory")
+		}
+	}
+	sort.SliceStable(problems, func(i, j int) bool {
+		return problems[i].(configproblem).line < problems[j].(configproblem).line
+	})
+	return append(problems, checkoverrides(file, c)...)
+}
+
+// checkoverrides makes sure the override file parses, only sets event
+// fields, and only moves events to rooms that are known.
+func checkoverrides(file string, c *config) (problems []error) {
+	if c.Overrides == "" {
+		return nil
+	}
+	buf, err := os.ReadFile(c.Overrides)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return []error{configproblem{file, 0, "Overrides", err.Error()}}
+	}
+	overrides := map[string]override{}
+	if err := json.Unmarshal(buf, &overrides); err != nil {
+		return []error{configproblem{c.Overrides, 0, "", err.Error()}}
+	}
+
+	rooms := map[location]bool{}
+	for room := range c.Rooms {
+		rooms[room] = true
+	}
+	if cached, err := loadcache(); err == nil {
+		for _, e := range cached {
+			rooms[e.Place] = true
+		}
+	}
+	lines := configlines(buf)
+	uids := make([]string, 0, len(overrides))
+	for uid := range overrides {
+		uids = append(uids, uid)
+	}
+	sort.Strings(uids)
+	for _, uid := range uids {
+		for k, v := range overrides[uid].Set {
+			path := uid + ".Set." + k
+			if _, ok := jsonfield(reflect.TypeOf(event{}), k); !ok {
+				problems = append(problems, configproblem{c.Overrides, lines[path], path, "events have no field " + k})
+				continue
+			}
+			if room, ok := v.(string); ok && strings.EqualFold(k, "Place") && len(rooms) > 0 && !rooms[location(room)] {
+				problems = append(problems, configproblem{c.Overrides, lines[path], path, fmt.Sprintf("unknown room %q", room)})
+			}
+		}
+	}
+	return problems
+}
+
+// decodeconfig decodes buf over a copy of base and validates the result.
+func decodeconfig(file string, buf []byte, base config) (config, error) {
+	c := base
+	if err := json.Unmarshal(buf, &c); err != nil {
+		var syntax *json.SyntaxError
+		var typ *json.UnmarshalTypeError
+		switch {
+		case errors.As(err, &syntax):
+			return c, configproblem{file, 1 + bytes.Count(buf[:syntax.Offset], []byte{'\n'}), "", syntax.Error()}
+		case errors.As(err, &typ):
+			return c, configproblem{file, 1 + bytes.Count(buf[:typ.Offset], []byte{'\n'}), typ.Field, fmt.Sprintf("cannot use %s as %s", typ.Value, typ.Type)}
+		}
+		return c, configproblem{file, 0, "", err.Error()}
+	}
+	return c, errors.Join(checkconfig(file, buf, &c)...)
+}
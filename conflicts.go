@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+type conflict struct {
+	Reason string
+	A, B   event
+}
+
+func (e *event) Overlaps(o *event) bool {
+	return e.Starttime().Before(o.Endtime()) && o.Starttime().Before(e.Endtime())
+}
+
+func (e *event) Speakers() (ret []string) {
+	for _, s := range strings.Split(e.Speaker, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			ret = append(ret, s)
+		}
+	}
+	return
+}
+
+func conflicts(c calendar) (ret []conflict) {
+	sorted := append(calendar{}, c...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Starttime().Before(sorted[j].Starttime())
+	})
+
+	for i := range sorted {
+		a := &sorted[i]
+		for j := i + 1; j < len(sorted); j++ {
+			b := &sorted[j]
+			if !b.Starttime().Before(a.Endtime()) {
+				break
+			}
+			if !a.Overlaps(b) {
+				continue
+			}
+			if a.Place != "" && a.Place == b.Place {
+				ret = append(ret, conflict{"room " + a.Place.String(), *a, *b})
+			}
+			for _, s := range a.Speakers() {
+				for _, t := range b.Speakers() {
+					if strings.EqualFold(s, t) {
+						ret = append(ret, conflict{"speaker " + s, *a, *b})
+					}
+				}
+			}
+		}
+	}
+	return
+}
+
+func handleconflicts(w http.ResponseWriter, r *http.Request) {
+	rendercached(w, r, "conflicts.html", 0, func(snap *snapshot) any {
+		return conflicts(snap.schedule)
+	})
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const davprefix = "/dav/"
+
+func (s *snapshot) modtime() time.Time {
+	t, _ := time.Parse("20060102T150405Z", s.stamp)
+	return t
+}
+
+// davetag is derived from the file itself, so that mirrors only fetch
+// the calendars that actually changed with a new snapshot.
+func davetag(ical []byte) string {
+	sum := sha256.Sum256(ical)
+	return `"` + hex.EncodeToString(sum[:8]) + `"`
+}
+
+func davfile(snap *snapshot, l location) davresponse {
+	return davresponse{davprefix + url.PathEscape(l.String()+".ics"), "<d:resourcetype/>" +
+		"<d:displayname>" + xmlescape(l.DisplayName()) + "</d:displayname>" +
+		"<d:getcontenttype>text/calendar</d:getcontenttype>" +
+		"<d:getcontentlength>" + strconv.Itoa(len(snap.icals[l])) + "</d:getcontentlength>" +
+		"<d:getetag>" + xmlescape(davetag(snap.icals[l])) + "</d:getetag>" +
+		"<d:getlastmodified>" + snap.modtime().UTC().Format(http.TimeFormat) + "</d:getlastmodified>"}
+}
+
+// handledav serves the calendars as a read-only WebDAV collection, for
+// mounting them or mirroring them with standard tools.
+func handledav(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("DAV", "1")
+	w.Header().Set("Allow", "OPTIONS, GET, HEAD, PROPFIND")
+	snap := current()
+	name := strings.TrimPrefix(r.URL.Path, davprefix)
+	l := location(strings.TrimSuffix(name, ".ics"))
+	ical, ok := snap.icals[l]
+	if name != "" && (!ok || !strings.HasSuffix(name, ".ics")) {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch r.Method {
+	case "OPTIONS":
+	case "GET", "HEAD":
+		if name == "" {
+			http.Error(w, "this is a WebDAV collection", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "text/calendar")
+		w.Header().Set("ETag", davetag(ical))
+		http.ServeContent(w, r, name, snap.modtime(), bytes.NewReader(ical))
+	case "PROPFIND":
+		if name != "" {
+			writemultistatus(w, []davresponse{davfile(snap, l)})
+			return
+		}
+		responses := []davresponse{{davprefix, "<d:resourcetype><d:collection/></d:resourcetype><d:displayname>gpnsched</d:displayname>"}}
+		if r.Header.Get("Depth") != "0" {
+			var rooms []location
+			for l := range snap.icals {
+				rooms = append(rooms, l)
+			}
+			sort.Slice(rooms, func(i, j int) bool { return rooms[i] < rooms[j] })
+			for _, l := range rooms {
+				responses = append(responses, davfile(snap, l))
+			}
+		}
+		writemultistatus(w, responses)
+	default:
+		http.Error(w, "read only", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// conferenceday is the day an event starting at t belongs to. Night
+// sessions before DayChange o'clock still count as the previous day.
+func conferenceday(t time.Time) time.Time {
+	t = t.In(loc).Add(-time.Duration(conf().DayChange) * time.Hour)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+}
+
+func daystart(day time.Time) time.Time {
+	return time.Date(day.Year(), day.Month(), day.Day(), conf().DayChange, 0, 0, 0, loc)
+}
+
+func parseday(s string) (time.Time, error) {
+	return time.ParseInLocation("2006-01-02", s, loc)
+}
+
+func (c calendar) Day(day time.Time) (ret calendar) {
+	for _, e := range c {
+		if conferenceday(e.Starttime()).Equal(day) {
+			ret = append(ret, e)
+		}
+	}
+	return
+}
+
+func conferencedays(c calendar) (ret []time.Time) {
+	for _, e := range c {
+		if day := conferenceday(e.Starttime()); !slices.ContainsFunc(ret, day.Equal) {
+			ret = append(ret, day)
+		}
+	}
+	slices.SortFunc(ret, time.Time.Compare)
+	return
+}
+
+type daytab struct {
+	Day     time.Time
+	URL     string
+	Current bool
+}
+
+func daytabs(days []time.Time, current time.Time, url func(time.Time) string) (ret []daytab) {
+	for _, day := range days {
+		ret = append(ret, daytab{day, url(day), day.Equal(current)})
+	}
+	return
+}
+
+type daypage struct {
+	timetableday
+	Tabs []daytab
+}
+
+func (d timetableday) Room(l location) (ret calendar) {
+	for _, e := range d.Events {
+		if e.Place == l {
+			ret = append(ret, e)
+		}
+	}
+	return ret.sorted()
+}
+
+func handledays(w http.ResponseWriter, r *http.Request) {
+	snap := current()
+	days := conferencedays(snap.schedule)
+	url := func(day time.Time) string { return "/days/" + day.Format("2006-01-02") }
+	rest := strings.TrimPrefix(r.URL.Path, "/days/")
+	if rest == "" {
+		if len(days) == 0 {
+			http.NotFound(w, r)
+			return
+		}
+		day := days[0]
+		if today := conferenceday(now()); slices.ContainsFunc(days, today.Equal) {
+			day = today
+		}
+		http.Redirect(w, r, url(day), http.StatusFound)
+		return
+	}
+
+	day, err := parseday(rest)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	for _, d := range timetabledays(snap.schedule) {
+		if d.Day.Equal(day) {
+			render(w, r, "day.html", daypage{d, daytabs(days, day, url)})
+			return
+		}
+	}
+	http.NotFound(w, r)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+type changekind string
+
+const (
+	added   changekind = "added"
+	removed changekind = "removed"
+	changed changekind = "changed"
+)
+
+type change struct {
+	Kind   changekind
+	Old    *event
+	New    *event
+	Fields []string
+}
+
+func (c change) String() string {
+	switch c.Kind {
+	case added:
+		return fmt.Sprintf("+ %s %s %s", c.New.Start, c.New.Place, c.New.Titlestring())
+	case removed:
+		return fmt.Sprintf("- %s %s %s", c.Old.Start, c.Old.Place, c.Old.Titlestring())
+	}
+	return fmt.Sprintf("~ %s %s %s: %v", c.New.Start, c.New.Place, c.New.Titlestring(), c.Fields)
+}
+
+func (e *event) Key() string {
+	return e.Title + "\x00" + e.Speaker
+}
+
+func changedfields(a, b *event) (ret []string) {
+	fields := []struct {
+		name string
+		a, b string
+	}{
+		{"Start", a.Start, b.Start},
+		{"End", a.End, b.End},
+		{"Place", a.Place.String(), b.Place.String()},
+		{"Type", a.Type, b.Type},
+		{"Affiliation", a.Affiliation, b.Affiliation},
+		{"Desc", a.Desc, b.Desc},
+		{"Long_desc", a.Long_desc, b.Long_desc},
+		{"Link", a.Link, b.Link},
+		{"Confirmed", a.Confirmed, b.Confirmed},
+		{"Language", a.Language, b.Language},
+	}
+	for _, f := range fields {
+		if f.a != f.b {
+			ret = append(ret, f.name)
+		}
+	}
+	if a.Do_not_record != b.Do_not_record {
+		ret = append(ret, "Do_not_record")
+	}
+	return
+}
+
+func bykey(c calendar) map[string][]*event {
+	ret := map[string][]*event{}
+	for i := range c {
+		ret[c[i].Key()] = append(ret[c[i].Key()], &c[i])
+	}
+	for _, evs := range ret {
+		sort.SliceStable(evs, func(i, j int) bool { return evs[i].Starttime().Before(evs[j].Starttime()) })
+	}
+	return ret
+}
+
+func diffschedules(old, new calendar) (ret []change) {
+	olds, news := bykey(old), bykey(new)
+	for key, n := range news {
+		o := olds[key]
+		for i := range n {
+			if i >= len(o) {
+				ret = append(ret, change{Kind: added, New: n[i]})
+			} else if fields := changedfields(o[i], n[i]); len(fields) > 0 {
+				ret = append(ret, change{Kind: changed, Old: o[i], New: n[i], Fields: fields})
+			}
+		}
+		for i := len(n); i < len(o); i++ {
+			ret = append(ret, change{Kind: removed, Old: o[i]})
+		}
+	}
+	for key, o := range olds {
+		if _, ok := news[key]; !ok {
+			for _, e := range o {
+				ret = append(ret, change{Kind: removed, Old: e})
+			}
+		}
+	}
+	sort.SliceStable(ret, func(i, j int) bool { return ret[i].event().Starttime().Before(ret[j].event().Starttime()) })
+	return
+}
+
+func (c change) event() *event {
+	if c.New != nil {
+		return c.New
+	}
+	return c.Old
+}
+
+func diffcmd(args []string) int {
+	fs, configfile := configflags("diff")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gpnsched diff [-config file] old [new]")
+		fs.PrintDefaults()
+	}
+	parseflags(fs, configfile, args)
+	if fs.NArg() < 1 {
+		fs.Usage()
+		return 2
+	}
+	newsource := conf().Source
+	if fs.NArg() > 1 {
+		newsource = fs.Arg(1)
+	}
+
+	old, err := fetchschedule(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	new, err := fetchschedule(newsource)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	changes := diffschedules(old, new)
+	for _, c := range changes {
+		fmt.Println(c)
+	}
+	if len(changes) > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+type doorsignday struct {
+	Day    time.Time
+	Events calendar
+}
+
+type doorsign struct {
+	Room location
+	Days []doorsignday
+	Tabs []daytab
+}
+
+func newdoorsign(room location, c calendar) doorsign {
+	d := doorsign{Room: room}
+	sorted := append(calendar(nil), c...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Starttime().Before(sorted[j].Starttime())
+	})
+	for _, e := range sorted {
+		day := conferenceday(e.Starttime())
+		if n := len(d.Days); n == 0 || !d.Days[n-1].Day.Equal(day) {
+			d.Days = append(d.Days, doorsignday{Day: day})
+		}
+		d.Days[len(d.Days)-1].Events = append(d.Days[len(d.Days)-1].Events, e)
+	}
+	return d
+}
+
+func handledoorsign(w http.ResponseWriter, r *http.Request) {
+	room := location(strings.TrimPrefix(r.URL.Path, "/doorsign/"))
+	if room == "" || room == "Alle" {
+		http.NotFound(w, r)
+		return
+	}
+
+	c := current().schedule.Room(room)
+	if len(c) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	// without a day the sign shows all of them, for printing
+	var day time.Time
+	if s := r.URL.Query().Get("day"); s != "" {
+		var err error
+		if day, err = parseday(s); err != nil {
+			http.Error(w, "day: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	d := newdoorsign(room, c)
+	d.Tabs = daytabs(conferencedays(c), day, func(day time.Time) string { return "?day=" + day.Format("2006-01-02") })
+	if !day.IsZero() {
+		d.Days = slices.DeleteFunc(d.Days, func(sd doorsignday) bool { return !sd.Day.Equal(day) })
+	}
+	render(w, r, "doorsign.html", d)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+)
+
+func (e *event) Warnings() (ret []string) {
+	for _, a := range e.anomalies() {
+		ret = append(ret, a.Detail)
+	}
+	return
+}
+
+func dryrun(current string) int {
+	fetched, err := loadschedule("")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	events, hidden := prepareschedule(fetched)
+
+	rooms := map[location]int{}
+	for _, e := range events {
+		rooms[e.Place]++
+	}
+	var names []location
+	for room := range rooms {
+		names = append(names, room)
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i] < names[j] })
+
+	var sources []string
+	for _, s := range configuredsources() {
+		if !s.Disabled {
+			sources = append(sources, s.Name)
+		}
+	}
+	fmt.Printf("%s: %d events\n", strings.Join(sources, ", "), len(events))
+	for _, room := range names {
+		fmt.Printf("  %-30s %d\n", room, rooms[room])
+	}
+	for _, room := range slices.Sorted(maps.Keys(hidden)) {
+		fmt.Printf("  %-30s %d (private)\n", room, len(hidden[room]))
+	}
+
+	for _, e := range fetched {
+		for _, w := range e.Warnings() {
+			fmt.Printf("warning: %s %s: %s\n", e.Start, e.Titlestring(), w)
+		}
+	}
+
+	if current != "" {
+		old, err := fetchschedule(current)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		old, _ = prepareschedule(old)
+		changes := diffschedules(old, events)
+		fmt.Printf("%d changes compared to %s\n", len(changes), current)
+		for _, c := range changes {
+			fmt.Println(c)
+		}
+	}
+	return 0
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// engelshift is one entry of Engelsystem's shifts-json-export. Older
+// versions only have the unix timestamps, newer ones the RFC 3339 dates.
+type engelshift struct {
+	Title          string
+	Name           string
+	Shifttype_name string
+	Room_name      string
+	Description    string
+	URL            string
+	Start_date     string
+	End_date       string
+	Starttime      json.RawMessage
+	Endtime        json.RawMessage
+}
+
+type angelshifts struct {
+	fetched time.Time
+	shifts  calendar
+	err     error
+}
+
+// fresh reports whether the cache entry can still be served. Rejected keys
+// are remembered only briefly, so a key fixed in Engelsystem works soon.
+func (c angelshifts) fresh(now time.Time) bool {
+	if c.err != nil {
+		return now.Sub(c.fetched) < angelkeycachetime
+	}
+	return now.Sub(c.fetched) < angelcachetime
+}
+
+// angelfetch is a request to Engelsystem in flight; done is closed once
+// shifts and err are set.
+type angelfetch struct {
+	done   chan struct{}
+	shifts calendar
+	err    error
+}
+
+const (
+	angelcachetime    = 5 * time.Minute
+	angelkeycachetime = 30 * time.Second
+)
+
+var (
+	errangelkey  = errors.New("unknown Engelsystem API key")
+	angelmutex   = sync.Mutex{}
+	angelcache   = map[string]angelshifts{}
+	angelfetches = map[string]*angelfetch{}
+	angelclient  = &http.Client{Timeout: 30 * time.Second}
+)
+
+func engeltime(date string, unix json.RawMessage) (time.Time, bool) {
+	if t, err := time.Parse(time.RFC3339, date); err == nil {
+		return t, true
+	}
+	s := strings.Trim(string(unix), "\"")
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil && n > 0 {
+		return time.Unix(n, 0), true
+	}
+	return time.Time{}, false
+}
+
+func (s engelshift) event() (event, bool) {
+	start, ok := engeltime(s.Start_date, s.Starttime)
+	if !ok {
+		return event{}, false
+	}
+	end, ok := engeltime(s.End_date, s.Endtime)
+	if !ok {
+		return event{}, false
+	}
+	title := cmp.Or(s.Title, s.Name, s.Shifttype_name)
+	if s.Shifttype_name != "" && s.Shifttype_name != title {
+		title = s.Shifttype_name + ": " + title
+	}
+	return event{
+		Start: gpntime(start),
+		End:   gpntime(end),
+		Type:  "shift",
+		Title: "Angel shift: " + title,
+		Desc:  s.Description,
+		Link:  s.URL,
+		Place: location(s.Room_name),
+	}, true
+}
+
+func fetchangelshifts(key string) (calendar, error) {
+	u := strings.TrimSuffix(conf().Engelsystem.URL, "/") + "/shifts-json-export?key=" + url.QueryEscape(key)
+	resp, err := angelclient.Get(u)
+	if err != nil {
+		// the error carries the URL and with it the key
+		return nil, errors.New("Engelsystem unreachable")
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound:
+		return nil, errangelkey
+	default:
+		return nil, fmt.Errorf("Engelsystem: %s", resp.Status)
+	}
+	buf, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	var shifts []engelshift
+	if err := json.Unmarshal(buf, &shifts); err != nil {
+		// Engelsystem answers invalid keys with an HTML login page
+		return nil, errangelkey
+	}
+	ret := calendar{}
+	for _, s := range shifts {
+		if e, ok := s.event(); ok {
+			ret = append(ret, e)
+		}
+	}
+	return ret, nil
+}
+
+// shiftsfor returns the shifts of the angel owning key. They are cached
+// briefly, so calendar clients polling every minute don't hammer Engelsystem.
+// The fetch runs without holding angelmutex; concurrent requests for the
+// same key wait for it instead of starting their own.
+func shiftsfor(key string) (calendar, error) {
+	angelmutex.Lock()
+	now := time.Now()
+	if c, ok := angelcache[key]; ok && c.fresh(now) {
+		angelmutex.Unlock()
+		return c.shifts, c.err
+	}
+	if f, ok := angelfetches[key]; ok {
+		angelmutex.Unlock()
+		<-f.done
+		return f.shifts, f.err
+	}
+	f := &angelfetch{done: make(chan struct{})}
+	angelfetches[key] = f
+	angelmutex.Unlock()
+
+	f.shifts, f.err = fetchangelshifts(key)
+
+	angelmutex.Lock()
+	delete(angelfetches, key)
+	for k, c := range angelcache {
+		if !c.fresh(now) {
+			delete(angelcache, k)
+		}
+	}
+	if f.err == nil || errors.Is(f.err, errangelkey) {
+		angelcache[key] = angelshifts{fetched: now, shifts: f.shifts, err: f.err}
+	}
+	angelmutex.Unlock()
+	close(f.done)
+	return f.shifts, f.err
+}
+
+// handleangels serves /angels/<key>.ics, the public schedule combined with
+// the shifts of the angel whose Engelsystem API key is in the URL. The key is
+// the credential; it is passed on to Engelsystem and never stored.
+func handleangels(w http.ResponseWriter, r *http.Request) {
+	key, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/angels/"), ".ics")
+	if conf().Engelsystem.URL == "" || !ok || key == "" {
+		http.NotFound(w, r)
+		return
+	}
+	shifts, err := shiftsfor(key)
+	if errors.Is(err, errangelkey) {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	c := append(slices.Clone(current().schedule), shifts...)
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Add("Content-Type", "text/calendar")
+	w.Write(c.ICalWith(requesticaloptions(r.URL.Query())))
+}
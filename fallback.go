@@ -0,0 +1,12 @@
+//go:build fallback
+
+package main
+
+import _ "embed"
+
+//go:embed fallback.json
+var embeddedfallback []byte
+
+func init() {
+	fallbackschedule = embeddedfallback
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Feedback returns the feedback URL of a talk once it is over, from the
+// Feedback template with {uid}, {slug} and {title} filled in. It depends on
+// the clock, so watchfeedback republishes whenever a talk ends.
+func (e *event) Feedback() string {
+	if conf().Feedback == "" || e.Type == "break" || e.Type == "shift" || !now().After(e.Endtime()) {
+		return ""
+	}
+	return strings.NewReplacer(
+		"{uid}", e.UID(),
+		"{slug}", slugify(e.Title),
+		"{title}", url.QueryEscape(e.Title),
+	).Replace(conf().Feedback)
+}
+
+func endedtalks(c calendar, t time.Time) (n int) {
+	for _, e := range c {
+		if e.Endtime().Before(t) {
+			n++
+		}
+	}
+	return
+}
+
+func watchfeedback() {
+	ended := -1
+	for range time.Tick(time.Minute) {
+		if conf().Feedback == "" {
+			ended = -1
+			continue
+		}
+		n := endedtalks(current().schedule, now())
+		if ended >= 0 && n != ended {
+			triggerrefresh()
+		}
+		ended = n
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// personalfeed is a user's selection of events. Its secret is the only
+// credential: whoever knows the URL can read and edit it.
+type personalfeed struct {
+	Events []string
+	Alarm  int
+}
+
+type feedpage struct {
+	Secret string
+	Webcal string
+	URL    string
+	Alarm  int
+	Events calendar
+}
+
+var (
+	feedsmutex = sync.Mutex{}
+	feeds      map[string]personalfeed
+)
+
+// loadfeeds must be called with feedsmutex held.
+func loadfeeds() error {
+	if feeds != nil {
+		return nil
+	}
+	buf, err := os.ReadFile(conf().Feeds)
+	if os.IsNotExist(err) {
+		feeds = map[string]personalfeed{}
+		return nil
+	} else if err != nil {
+		return err
+	}
+	loaded := map[string]personalfeed{}
+	if err := json.Unmarshal(buf, &loaded); err != nil {
+		return err
+	}
+	feeds = loaded
+	return nil
+}
+
+// savefeeds must be called with feedsmutex held.
+func savefeeds() error {
+	buf, err := json.Marshal(feeds)
+	if err != nil {
+		return err
+	}
+	tmp := conf().Feeds + ".tmp"
+	if err := os.WriteFile(tmp, buf, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, conf().Feeds)
+}
+
+func newfeedsecret() string {
+	buf := make([]byte, 20)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+func getfeed(secret string) (personalfeed, bool, error) {
+	feedsmutex.Lock()
+	defer feedsmutex.Unlock()
+	if err := loadfeeds(); err != nil {
+		return personalfeed{}, false, err
+	}
+	f, ok := feeds[secret]
+	return f, ok, nil
+}
+
+// updatefeed applies fn to the feed stored under secret, or to a new one if
+// secret is empty or unknown, and returns the secret it is stored under. fn
+// decides whether the feed moves to a fresh secret or is deleted.
+func updatefeed(secret string, fn func(f *personalfeed) (regenerate, keep bool)) (string, error) {
+	feedsmutex.Lock()
+	defer feedsmutex.Unlock()
+	if err := loadfeeds(); err != nil {
+		return "", err
+	}
+	f, ok := feeds[secret]
+	delete(feeds, secret)
+	regenerate, keep := fn(&f)
+	if !ok || regenerate {
+		secret = newfeedsecret()
+	}
+	if keep {
+		feeds[secret] = f
+	}
+	return secret, savefeeds()
+}
+
+func (f personalfeed) calendar(c calendar) calendar {
+	ret := calendar{}
+	for _, e := range c {
+		if slices.Contains(f.Events, e.UID()) {
+			ret = append(ret, e)
+		}
+	}
+	return ret
+}
+
+func handlefeeds(w http.ResponseWriter, r *http.Request) {
+	if conf().Feeds == "" {
+		http.NotFound(w, r)
+		return
+	}
+	secret := strings.TrimPrefix(r.URL.Path, "/feeds/")
+	if r.Method == http.MethodPost {
+		handlefeedupdate(w, r, secret)
+		return
+	}
+
+	secret, ics := strings.CutSuffix(secret, ".ics")
+	f, ok, err := getfeed(secret)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	} else if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	c := f.calendar(current().schedule)
+	if ics {
+		opt := requesticaloptions(r.URL.Query())
+		opt.alarm = time.Duration(f.Alarm) * time.Minute
+		w.Header().Add("Content-Type", "text/calendar")
+		w.Write(c.ICalWith(opt))
+		return
+	}
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	render(w, r, "feed.html", feedpage{
+		Secret: secret,
+		Webcal: "webcal://" + r.Host + "/feeds/" + secret + ".ics",
+		URL:    baseurl(r) + "/feeds/" + secret + ".ics",
+		Alarm:  f.Alarm,
+		Events: c,
+	})
+}
+
+// handlefeedupdate edits the feed named by secret, or by the feed cookie when
+// events are added from an event page.
+func handlefeedupdate(w http.ResponseWriter, r *http.Request, secret string) {
+	if secret == "" {
+		if c, err := r.Cookie("feed"); err == nil {
+			secret = c.Value
+		}
+	}
+	action := r.FormValue("action")
+	uid := r.FormValue("event")
+	next, err := updatefeed(secret, func(f *personalfeed) (bool, bool) {
+		switch action {
+		case "remove":
+			f.Events = slices.DeleteFunc(f.Events, func(u string) bool { return u == uid })
+		case "alarm":
+			f.Alarm, _ = strconv.Atoi(r.FormValue("alarm"))
+			f.Alarm = max(f.Alarm, 0)
+		case "regenerate":
+			return true, true
+		case "delete":
+			return false, false
+		default:
+			if uid != "" && !slices.Contains(f.Events, uid) {
+				f.Events = append(f.Events, uid)
+			}
+		}
+		return false, true
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if action == "delete" {
+		http.SetCookie(w, &http.Cookie{Name: "feed", Path: "/", MaxAge: -1})
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: "feed", Value: next, Path: "/", MaxAge: 365 * 24 * 3600, HttpOnly: true, SameSite: http.SameSiteLaxMode})
+	http.Redirect(w, r, "/feeds/"+next, http.StatusSeeOther)
+}
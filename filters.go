@@ -0,0 +1,85 @@
+package main
+
+import (
+	"log"
+	"regexp"
+	"slices"
+)
+
+type eventfilter struct {
+	Rooms  []location
+	Types  []string
+	Titles []string
+}
+
+func (f *eventfilter) empty() bool {
+	return len(f.Rooms) == 0 && len(f.Types) == 0 && len(f.Titles) == 0
+}
+
+func (f *eventfilter) matcher() func(*event) bool {
+	var titles []*regexp.Regexp
+	for _, p := range f.Titles {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			log.Println("invalid title pattern:", err)
+			continue
+		}
+		titles = append(titles, re)
+	}
+	return func(e *event) bool {
+		for _, r := range f.Rooms {
+			if e.Place == r {
+				return true
+			}
+		}
+		for _, t := range f.Types {
+			if e.Type == t {
+				return true
+			}
+		}
+		for _, re := range titles {
+			if re.MatchString(e.Title) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Exclude drops the events in the given rooms or of the given types, e.g.
+// for subscribers who want everything except the music programme.
+func (c calendar) Exclude(names []string) (ret calendar) {
+	var slugs []string
+	for _, n := range names {
+		if slug := slugify(n); slug != "" {
+			slugs = append(slugs, slug)
+		}
+	}
+	match := func(s string) bool {
+		return s != "" && slices.Contains(slugs, slugify(s))
+	}
+	for _, e := range c {
+		if !match(string(e.Place)) && !match(e.Type) {
+			ret = append(ret, e)
+		}
+	}
+	return
+}
+
+// filterevents drops denied events and, if an allow list is configured, all
+// events not on it.
+func filterevents(events calendar) calendar {
+	if conf().Deny.empty() && conf().Allow.empty() {
+		return events
+	}
+	denied, allowed := conf().Deny.matcher(), conf().Allow.matcher()
+	ret := calendar{}
+	for i := range events {
+		e := &events[i]
+		if denied(e) || !conf().Allow.empty() && !allowed(e) {
+			continue
+		}
+		ret = append(ret, *e)
+	}
+	return ret
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+type frabperson struct {
+	PublicName string `json:"public_name"`
+}
+
+type frablink struct {
+	URL   string `json:"url"`
+	Title string `json:"title"`
+}
+
+type frabevent struct {
+	GUID        string       `json:"guid"`
+	ID          int          `json:"id"`
+	Date        string       `json:"date"`
+	Start       string       `json:"start"`
+	Duration    string       `json:"duration"`
+	Room        string       `json:"room"`
+	Slug        string       `json:"slug"`
+	URL         string       `json:"url"`
+	Title       string       `json:"title"`
+	Subtitle    string       `json:"subtitle"`
+	Track       string       `json:"track"`
+	Type        string       `json:"type"`
+	Language    string       `json:"language"`
+	Abstract    string       `json:"abstract"`
+	Description string       `json:"description"`
+	DoNotRecord bool         `json:"do_not_record"`
+	Persons     []frabperson `json:"persons"`
+	Links       []frablink   `json:"links"`
+	start       time.Time
+}
+
+type frabroom struct {
+	Name string `json:"name"`
+	GUID string `json:"guid"`
+}
+
+type frabday struct {
+	Index    int                    `json:"index"`
+	Date     string                 `json:"date"`
+	DayStart string                 `json:"day_start"`
+	DayEnd   string                 `json:"day_end"`
+	Rooms    map[string][]frabevent `json:"rooms"`
+}
+
+type frabconference struct {
+	Acronym          string     `json:"acronym"`
+	Title            string     `json:"title"`
+	Start            string     `json:"start"`
+	End              string     `json:"end"`
+	DaysCount        int        `json:"daysCount"`
+	TimeslotDuration string     `json:"timeslot_duration"`
+	TimeZoneName     string     `json:"time_zone_name"`
+	Rooms            []frabroom `json:"rooms"`
+	Days             []frabday  `json:"days"`
+}
+
+type frabschedule struct {
+	Version    string         `json:"version"`
+	BaseURL    string         `json:"base_url"`
+	Conference frabconference `json:"conference"`
+}
+
+// frabguid derives a stable UUID from s.
+func frabguid(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	sum[6] = sum[6]&0x0f | 0x50
+	sum[8] = sum[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
+
+func frabid(s string) int {
+	sum := sha256.Sum256([]byte(s))
+	return int(binary.BigEndian.Uint32(sum[:4]) & 0x7fffffff)
+}
+
+func hhmm(d time.Duration) string {
+	return fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes())%60)
+}
+
+func newfrabevent(e *event, base string) frabevent {
+	uid := e.UID()
+	start, end := e.Starttime().In(loc), e.Endtime().In(loc)
+	fe := frabevent{
+		GUID:        frabguid(uid),
+		ID:          frabid(uid),
+		Date:        start.Format(time.RFC3339),
+		Start:       start.Format("15:04"),
+		Duration:    hhmm(end.Sub(start)),
+		Room:        e.Place.DisplayName(),
+		Slug:        conf().Conference.Acronym + "-" + slugify(e.Title),
+		URL:         base + "/events/" + uid,
+		Title:       e.Title,
+		Track:       e.Track().Name,
+		Type:        e.Type,
+		Language:    e.Language,
+		Abstract:    e.Desc,
+		Description: e.Long_desc,
+		DoNotRecord: e.Do_not_record,
+		Persons:     []frabperson{},
+		Links:       []frablink{},
+		start:       start,
+	}
+	for _, s := range e.Speakers() {
+		fe.Persons = append(fe.Persons, frabperson{s})
+	}
+	if e.Link != "" {
+		fe.Links = append(fe.Links, frablink{e.Link, e.Link})
+	}
+	return fe
+}
+
+func newfrabschedule(c calendar, v, base string) frabschedule {
+	s := frabschedule{
+		Version: v,
+		BaseURL: base + "/",
+		Conference: frabconference{
+			Acronym:          conf().Conference.Acronym,
+			Title:            conf().Conference.Title,
+			Start:            gpnstart.Format("2006-01-02"),
+			End:              gpnstop.Format("2006-01-02"),
+			TimeslotDuration: "00:15",
+			TimeZoneName:     loc.String(),
+			Rooms:            []frabroom{},
+			Days:             []frabday{},
+		},
+	}
+
+	rooms := map[string]bool{}
+	days := map[string]*frabday{}
+	for i := range c {
+		e := &c[i]
+		if e.Place == "" || e.Type == "break" {
+			continue
+		}
+		fe := newfrabevent(e, base)
+		if !rooms[fe.Room] {
+			rooms[fe.Room] = true
+			s.Conference.Rooms = append(s.Conference.Rooms, frabroom{fe.Room, frabguid("room " + fe.Room)})
+		}
+		day := conferenceday(fe.start)
+		date := day.Format("2006-01-02")
+		d := days[date]
+		if d == nil {
+			d = &frabday{
+				Date:     date,
+				DayStart: daystart(day).Format(time.RFC3339),
+				DayEnd:   daystart(day.AddDate(0, 0, 1)).Format(time.RFC3339),
+				Rooms:    map[string][]frabevent{},
+			}
+			days[date] = d
+		}
+		d.Rooms[fe.Room] = append(d.Rooms[fe.Room], fe)
+	}
+
+	sort.Slice(s.Conference.Rooms, func(i, j int) bool { return s.Conference.Rooms[i].Name < s.Conference.Rooms[j].Name })
+	for _, d := range days {
+		for _, evs := range d.Rooms {
+			sort.SliceStable(evs, func(i, j int) bool { return evs[i].start.Before(evs[j].start) })
+		}
+		s.Conference.Days = append(s.Conference.Days, *d)
+	}
+	sort.Slice(s.Conference.Days, func(i, j int) bool { return s.Conference.Days[i].Date < s.Conference.Days[j].Date })
+	for i := range s.Conference.Days {
+		s.Conference.Days[i].Index = i + 1
+	}
+	s.Conference.DaysCount = len(s.Conference.Days)
+	return s
+}
+
+func handleschedulejson(w http.ResponseWriter, r *http.Request) {
+	snap := current()
+	s := newfrabschedule(snap.schedule, snap.version, baseurl(r))
+
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"$schema":   "https://c3voc.de/schedule/schema.json",
+		"generator": map[string]string{"name": "gpnsched"},
+		"schedule":  s,
+	})
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+)
+
+type frabxmlperson struct {
+	ID   int    `xml:"id,attr"`
+	Name string `xml:",chardata"`
+}
+
+type frabxmllink struct {
+	Href  string `xml:"href,attr"`
+	Title string `xml:",chardata"`
+}
+
+type frabxmlevent struct {
+	GUID        string `xml:"guid,attr"`
+	ID          int    `xml:"id,attr"`
+	Date        string `xml:"date"`
+	Start       string `xml:"start"`
+	Duration    string `xml:"duration"`
+	Room        string `xml:"room"`
+	Slug        string `xml:"slug"`
+	URL         string `xml:"url"`
+	Title       string `xml:"title"`
+	Subtitle    string `xml:"subtitle"`
+	Track       string `xml:"track"`
+	Type        string `xml:"type"`
+	Language    string `xml:"language"`
+	Abstract    string `xml:"abstract"`
+	Description string `xml:"description"`
+	Recording   struct {
+		License string `xml:"license"`
+		Optout  bool   `xml:"optout"`
+	} `xml:"recording"`
+	Persons     []frabxmlperson `xml:"persons>person"`
+	Links       []frabxmllink   `xml:"links>link"`
+	Attachments struct{}        `xml:"attachments"`
+}
+
+type frabxmlroom struct {
+	Name   string         `xml:"name,attr"`
+	GUID   string         `xml:"guid,attr"`
+	Events []frabxmlevent `xml:"event"`
+}
+
+type frabxmlday struct {
+	Index int           `xml:"index,attr"`
+	Date  string        `xml:"date,attr"`
+	Start string        `xml:"start,attr"`
+	End   string        `xml:"end,attr"`
+	Rooms []frabxmlroom `xml:"room"`
+}
+
+type frabxmlschedule struct {
+	XMLName    xml.Name `xml:"schedule"`
+	Version    string   `xml:"version"`
+	Conference struct {
+		Acronym          string `xml:"acronym"`
+		Title            string `xml:"title"`
+		Start            string `xml:"start"`
+		End              string `xml:"end"`
+		Days             int    `xml:"days"`
+		TimeslotDuration string `xml:"timeslot_duration"`
+		BaseURL          string `xml:"base_url"`
+		TimeZoneName     string `xml:"time_zone_name"`
+	} `xml:"conference"`
+	Days []frabxmlday `xml:"day"`
+}
+
+func newfrabxmlevent(fe frabevent) frabxmlevent {
+	xe := frabxmlevent{
+		GUID:        fe.GUID,
+		ID:          fe.ID,
+		Date:        fe.Date,
+		Start:       fe.Start,
+		Duration:    fe.Duration,
+		Room:        fe.Room,
+		Slug:        fe.Slug,
+		URL:         fe.URL,
+		Title:       fe.Title,
+		Subtitle:    fe.Subtitle,
+		Track:       fe.Track,
+		Type:        fe.Type,
+		Language:    fe.Language,
+		Abstract:    fe.Abstract,
+		Description: fe.Description,
+	}
+	xe.Recording.Optout = fe.DoNotRecord
+	for _, p := range fe.Persons {
+		xe.Persons = append(xe.Persons, frabxmlperson{frabid("person " + p.PublicName), p.PublicName})
+	}
+	for _, l := range fe.Links {
+		xe.Links = append(xe.Links, frabxmllink{l.URL, l.Title})
+	}
+	return xe
+}
+
+func newfrabxmlschedule(s frabschedule) frabxmlschedule {
+	var x frabxmlschedule
+	x.Version = s.Version
+	x.Conference.Acronym = s.Conference.Acronym
+	x.Conference.Title = s.Conference.Title
+	x.Conference.Start = s.Conference.Start
+	x.Conference.End = s.Conference.End
+	x.Conference.Days = s.Conference.DaysCount
+	x.Conference.TimeslotDuration = s.Conference.TimeslotDuration
+	x.Conference.BaseURL = s.BaseURL
+	x.Conference.TimeZoneName = s.Conference.TimeZoneName
+
+	for _, d := range s.Conference.Days {
+		xd := frabxmlday{Index: d.Index, Date: d.Date, Start: d.DayStart, End: d.DayEnd}
+		for _, r := range s.Conference.Rooms {
+			xr := frabxmlroom{Name: r.Name, GUID: r.GUID}
+			for _, fe := range d.Rooms[r.Name] {
+				xr.Events = append(xr.Events, newfrabxmlevent(fe))
+			}
+			xd.Rooms = append(xd.Rooms, xr)
+		}
+		x.Days = append(x.Days, xd)
+	}
+	return x
+}
+
+func handleschedulexml(w http.ResponseWriter, r *http.Request) {
+	snap := current()
+	s := newfrabschedule(snap.schedule, snap.version, baseurl(r))
+
+	w.Header().Add("Content-Type", "application/xml; charset=utf-8")
+	io.WriteString(w, xml.Header)
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	enc.Encode(newfrabxmlschedule(s))
+}
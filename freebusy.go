@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+type period struct {
+	start, end time.Time
+}
+
+func (c calendar) Busy() (ret []period) {
+	var periods []period
+	for _, e := range c {
+		if e.Type != "break" {
+			periods = append(periods, period{e.Starttime(), e.Endtime()})
+		}
+	}
+	sort.Slice(periods, func(i, j int) bool { return periods[i].start.Before(periods[j].start) })
+	for _, p := range periods {
+		if n := len(ret); n > 0 && !p.start.After(ret[n-1].end) {
+			if p.end.After(ret[n-1].end) {
+				ret[n-1].end = p.end
+			}
+			continue
+		}
+		ret = append(ret, p)
+	}
+	return
+}
+
+func (c calendar) FreeBusy(l location) []byte {
+	buf := getbuffer()
+	defer putbuffer(buf)
+	w := getlinewriter(buf, 75)
+	defer putlinewriter(w)
+	icalformatline(w, "BEGIN", "VCALENDAR")
+	icalformatline(w, "VERSION", "2.0")
+	icalformatline(w, "PRODID", "pff")
+	icalformatline(w, "METHOD", "PUBLISH")
+	icalformatline(w, "BEGIN", "VFREEBUSY")
+	icalformatline(w, "UID", "freebusy-"+l.String())
+	icalformatline(w, "DTSTAMP", icaldatetime(time.Now()))
+	icalformatline(w, "DTSTART", icaldatetime(gpnstart))
+	icalformatline(w, "DTEND", icaldatetime(gpnstop))
+	for _, p := range c.Busy() {
+		icalformatline(w, "FREEBUSY;FBTYPE=BUSY", icaldatetime(p.start)+"/"+icaldatetime(p.end))
+	}
+	icalformatline(w, "END", "VFREEBUSY")
+	icalformatline(w, "END", "VCALENDAR")
+	return bytes.Clone(buf.Bytes())
+}
+
+func handlefreebusy(w http.ResponseWriter, r *http.Request) {
+	l := location(strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/freebusy/"), ".ics"))
+
+	snap := current()
+	_, ok := snap.icals[l]
+	c := snap.schedule.Room(l)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Add("Content-Type", "text/calendar")
+	w.Write(c.FreeBusy(l))
+}
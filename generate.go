@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func icsfilename(l location) string {
+	return strings.NewReplacer("/", "_", "\\", "_").Replace(l.String()) + ".ics"
+}
+
+func generatecmd(args []string) int {
+	fs, configfile := configflags("generate")
+	source := fs.String("source", "", "schedule url or file (default: the configured sources)")
+	dir := fs.String("out", ".", "output directory")
+	parseflags(fs, configfile, args)
+
+	events, err := loadschedule(*source)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	// private rooms need a password, so they have no place in a public export
+	events, _ = prepareschedule(events)
+
+	if err := os.MkdirAll(*dir, 0755); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	for room, ical := range rendercalendars(events, defaulticaloptions()) {
+		name := filepath.Join(*dir, icsfilename(room))
+		if err := os.WriteFile(name, ical, 0644); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Println(name)
+	}
+	return 0
+}
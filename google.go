@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const googlecalendarapi = "https://www.googleapis.com/calendar/v3/calendars/"
+
+type googlecredentials struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+type googlepusher struct {
+	calendar string
+	creds    googlecredentials
+	key      *rsa.PrivateKey
+	client   *http.Client
+
+	token   string
+	expiry  time.Time
+	pushed  map[string]string
+	initial bool
+}
+
+type googledatetime struct {
+	DateTime string `json:"dateTime"`
+}
+
+type googleevent struct {
+	ID                 string         `json:"id,omitempty"`
+	ICalUID            string         `json:"iCalUID,omitempty"`
+	Status             string         `json:"status,omitempty"`
+	Summary            string         `json:"summary"`
+	Description        string         `json:"description"`
+	Location           string         `json:"location"`
+	Start              googledatetime `json:"start"`
+	End                googledatetime `json:"end"`
+	ExtendedProperties struct {
+		Private map[string]string `json:"private"`
+	} `json:"extendedProperties"`
+}
+
+func newgooglepusher(credentials, calendar string) (*googlepusher, error) {
+	buf, err := os.ReadFile(credentials)
+	if err != nil {
+		return nil, err
+	}
+	g := &googlepusher{
+		calendar: calendar,
+		client:   &http.Client{Timeout: 30 * time.Second},
+		pushed:   map[string]string{},
+		initial:  true,
+	}
+	if err := json.Unmarshal(buf, &g.creds); err != nil {
+		return nil, err
+	}
+	if g.creds.TokenURI == "" {
+		g.creds.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+
+	block, _ := pem.Decode([]byte(g.creds.PrivateKey))
+	if block == nil {
+		return nil, errors.New("google: no private key in credentials")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	var ok bool
+	if g.key, ok = key.(*rsa.PrivateKey); !ok {
+		return nil, errors.New("google: private key is not RSA")
+	}
+	return g, nil
+}
+
+func (g *googlepusher) accesstoken() (string, error) {
+	if g.token != "" && time.Now().Before(g.expiry) {
+		return g.token, nil
+	}
+
+	enc := base64.RawURLEncoding
+	header := enc.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+	iat := time.Now()
+	claims, _ := json.Marshal(map[string]any{
+		"iss":   g.creds.ClientEmail,
+		"scope": "https://www.googleapis.com/auth/calendar",
+		"aud":   g.creds.TokenURI,
+		"iat":   iat.Unix(),
+		"exp":   iat.Add(time.Hour).Unix(),
+	})
+	unsigned := header + "." + enc.EncodeToString(claims)
+	sum := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(nil, g.key, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := g.client.PostForm(g.creds.TokenURI, url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {unsigned + "." + enc.EncodeToString(sig)},
+	})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("google: token request: %s: %s", resp.Status, body)
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	g.token = token.AccessToken
+	g.expiry = iat.Add(time.Duration(token.ExpiresIn)*time.Second - time.Minute)
+	return g.token, nil
+}
+
+func (g *googlepusher) do(method, path string, body any, out any) (int, error) {
+	token, err := g.accesstoken()
+	if err != nil {
+		return 0, err
+	}
+	var r io.Reader
+	if body != nil {
+		buf, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		r = bytes.NewReader(buf)
+	}
+	req, err := http.NewRequest(method, googlecalendarapi+url.PathEscape(g.calendar)+path, r)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, fmt.Errorf("google: %s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if out != nil {
+		return resp.StatusCode, json.NewDecoder(resp.Body).Decode(out)
+	}
+	return resp.StatusCode, nil
+}
+
+func (g *googlepusher) load() error {
+	pagetoken := ""
+	for {
+		q := url.Values{"privateExtendedProperty": {"gpnsched=1"}, "maxResults": {"2500"}}
+		if pagetoken != "" {
+			q.Set("pageToken", pagetoken)
+		}
+		var page struct {
+			Items         []googleevent `json:"items"`
+			NextPageToken string        `json:"nextPageToken"`
+		}
+		if _, err := g.do("GET", "/events?"+q.Encode(), nil, &page); err != nil {
+			return err
+		}
+		for _, e := range page.Items {
+			g.pushed[e.ID] = e.ExtendedProperties.Private["hash"]
+		}
+		if pagetoken = page.NextPageToken; pagetoken == "" {
+			return nil
+		}
+	}
+}
+
+func newgoogleevent(e *event) googleevent {
+	ge := googleevent{
+		ID:          e.UID(),
+		ICalUID:     e.UID(),
+		Status:      "confirmed",
+		Summary:     e.Titlestring(),
+		Description: e.Description(),
+		Location:    e.Place.Metadata().Address(),
+		Start:       googledatetime{e.Starttime().Format(time.RFC3339)},
+		End:         googledatetime{e.Endtime().Format(time.RFC3339)},
+	}
+	ge.ExtendedProperties.Private = map[string]string{"gpnsched": "1", "hash": e.ETag()}
+	return ge
+}
+
+func (g *googlepusher) push(c calendar) {
+	if g.initial {
+		if err := g.load(); err != nil {
+			log.Println(err)
+			return
+		}
+		g.initial = false
+	}
+
+	current := map[string]bool{}
+	for i := range c {
+		e := &c[i]
+		uid := e.UID()
+		current[uid] = true
+		if g.pushed[uid] == e.ETag() {
+			continue
+		}
+		ge := newgoogleevent(e)
+		status, err := g.do("PUT", "/events/"+uid, ge, nil)
+		if status == http.StatusNotFound {
+			_, err = g.do("POST", "/events", ge, nil)
+		}
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		g.pushed[uid] = e.ETag()
+	}
+
+	for uid := range g.pushed {
+		if current[uid] {
+			continue
+		}
+		if status, err := g.do("DELETE", "/events/"+uid, nil, nil); err != nil && status != http.StatusGone && status != http.StatusNotFound {
+			log.Println(err)
+			continue
+		}
+		delete(g.pushed, uid)
+	}
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The gRPC service of proto/gpnsched.proto, spoken directly over net/http's
+// HTTP/2 support, as the tree doesn't vendor grpc-go. Messages are encoded by
+// hand; only what the service needs is implemented: no compression, no
+// metadata beyond the status trailers.
+
+const grpcprefix = "/gpnsched.Schedule/"
+
+// gRPC status codes
+const (
+	grpcok                 = 0
+	grpcinvalidargument    = 3
+	grpcnotfound           = 5
+	grpcfailedprecondition = 9
+	grpcunimplemented      = 12
+	grpcinternal           = 13
+)
+
+type grpcerror struct {
+	code int
+	msg  string
+}
+
+func (e grpcerror) Error() string {
+	return e.msg
+}
+
+// protobuf wire format
+
+func protovarint(buf []byte, field int, v uint64) []byte {
+	buf = binary.AppendUvarint(buf, uint64(field)<<3)
+	return binary.AppendUvarint(buf, v)
+}
+
+func protobytes(buf []byte, field int, b []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(field)<<3|2)
+	buf = binary.AppendUvarint(buf, uint64(len(b)))
+	return append(buf, b...)
+}
+
+// protostring leaves out empty strings, like proto3 does for defaults.
+func protostring(buf []byte, field int, s string) []byte {
+	if s == "" {
+		return buf
+	}
+	return protobytes(buf, field, []byte(s))
+}
+
+func prototimestamp(buf []byte, field int, t time.Time) []byte {
+	var ts []byte
+	if s := t.Unix(); s != 0 {
+		ts = protovarint(ts, 1, uint64(s))
+	}
+	if n := t.Nanosecond(); n != 0 {
+		ts = protovarint(ts, 2, uint64(n))
+	}
+	return protobytes(buf, field, ts)
+}
+
+// protostrings decodes a message of string fields, which is all the
+// requests consist of. Fields of other types are skipped.
+func protostrings(buf []byte) (map[int]string, error) {
+	ret := map[int]string{}
+	for len(buf) > 0 {
+		key, n := binary.Uvarint(buf)
+		if n <= 0 {
+			return nil, errors.New("bad field key")
+		}
+		buf = buf[n:]
+		field, wire := int(key>>3), key&7
+		switch wire {
+		case 0:
+			if _, n = binary.Uvarint(buf); n <= 0 {
+				return nil, errors.New("bad varint")
+			}
+			buf = buf[n:]
+		case 1, 5:
+			size := map[uint64]int{1: 8, 5: 4}[wire]
+			if len(buf) < size {
+				return nil, errors.New("truncated field")
+			}
+			buf = buf[size:]
+		case 2:
+			l, n := binary.Uvarint(buf)
+			if n <= 0 || uint64(len(buf)-n) < l {
+				return nil, errors.New("truncated field")
+			}
+			ret[field] = string(buf[n : n+int(l)])
+			buf = buf[n+int(l):]
+		default:
+			return nil, fmt.Errorf("unsupported wire type %d", wire)
+		}
+	}
+	return ret, nil
+}
+
+func (e *event) proto(lang string) []byte {
+	var buf []byte
+	buf = protostring(buf, 1, e.UID())
+	buf = protostring(buf, 2, e.Title)
+	buf = protostring(buf, 3, e.Speaker)
+	buf = protostring(buf, 4, e.Affiliation)
+	buf = protostring(buf, 5, e.Type)
+	buf = protostring(buf, 6, e.Place.String())
+	buf = prototimestamp(buf, 7, e.Starttime())
+	buf = prototimestamp(buf, 8, e.Endtime())
+	buf = protostring(buf, 9, e.DescriptionIn(lang))
+	buf = protostring(buf, 10, e.Link)
+	buf = protostring(buf, 11, e.Language)
+	if e.Do_not_record {
+		buf = protovarint(buf, 12, 1)
+	}
+	return buf
+}
+
+var protokinds = map[changekind]uint64{added: 1, removed: 2, changed: 3}
+
+func (c change) proto(version string) []byte {
+	lang := conf().Language
+	buf := protovarint(nil, 1, protokinds[c.Kind])
+	if c.Old != nil {
+		buf = protobytes(buf, 2, c.Old.proto(lang))
+	}
+	if c.New != nil {
+		buf = protobytes(buf, 3, c.New.proto(lang))
+	}
+	for _, f := range c.Fields {
+		buf = protostring(buf, 4, f)
+	}
+	return protostring(buf, 5, version)
+}
+
+// gRPC framing
+
+func readgrpcmessage(r io.Reader) ([]byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, grpcerror{grpcinvalidargument, "reading request: " + err.Error()}
+	}
+	if header[0] != 0 {
+		return nil, grpcerror{grpcunimplemented, "compressed requests are not supported"}
+	}
+	size := binary.BigEndian.Uint32(header[1:])
+	if size > 1<<16 {
+		return nil, grpcerror{grpcinvalidargument, "request too large"}
+	}
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, grpcerror{grpcinvalidargument, "reading request: " + err.Error()}
+	}
+	return buf, nil
+}
+
+func writegrpcmessage(w http.ResponseWriter, msg []byte) error {
+	frame := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+	if _, err := w.Write(append(frame, msg...)); err != nil {
+		return err
+	}
+	return http.NewResponseController(w).Flush()
+}
+
+// history of recent schedules, for WatchChanges clients catching up
+
+const grpchistory = 16
+
+var watchers = struct {
+	sync.Mutex
+	versions  []string
+	schedules map[string]calendar
+	changed   chan struct{}
+}{schedules: map[string]calendar{}, changed: make(chan struct{})}
+
+// announcesnapshot records a newly published snapshot and wakes up
+// WatchChanges streams.
+func announcesnapshot(snap *snapshot) {
+	watchers.Lock()
+	defer watchers.Unlock()
+	if _, ok := watchers.schedules[snap.version]; !ok {
+		watchers.versions = append(watchers.versions, snap.version)
+		watchers.schedules[snap.version] = snap.schedule
+		if len(watchers.versions) > grpchistory {
+			delete(watchers.schedules, watchers.versions[0])
+			watchers.versions = watchers.versions[1:]
+		}
+	}
+	close(watchers.changed)
+	watchers.changed = make(chan struct{})
+}
+
+func watchstate(version string) (calendar, bool, chan struct{}) {
+	watchers.Lock()
+	defer watchers.Unlock()
+	c, ok := watchers.schedules[version]
+	return c, ok, watchers.changed
+}
+
+// the RPCs
+
+func grpclistevents(req map[int]string) ([]byte, error) {
+	snap := current()
+	c := snap.schedule
+	if room := req[1]; room != "" {
+		l, ok := grpcroom(snap, room)
+		if !ok {
+			return nil, grpcerror{grpcnotfound, "unknown room " + strconv.Quote(room)}
+		}
+		c = c.Room(l)
+	}
+	lang := req[2]
+	if catalogs[lang] == nil {
+		lang = conf().Language
+	}
+	buf := protostring(nil, 1, snap.version)
+	for _, e := range c.sorted() {
+		buf = protobytes(buf, 2, e.proto(lang))
+	}
+	return buf, nil
+}
+
+func grpcroomnownext(req map[int]string) ([]byte, error) {
+	snap := current()
+	l, ok := grpcroom(snap, req[1])
+	if !ok {
+		return nil, grpcerror{grpcnotfound, "unknown room " + strconv.Quote(req[1])}
+	}
+	buf := protostring(nil, 1, l.String())
+	for _, r := range nownext(snap.schedule, now(), loc) {
+		if r.Room != l {
+			continue
+		}
+		if len(r.Now) > 0 {
+			buf = protobytes(buf, 2, r.Now[0].proto(conf().Language))
+		}
+		if r.Next != nil {
+			buf = protobytes(buf, 3, r.Next.proto(conf().Language))
+		}
+	}
+	return buf, nil
+}
+
+// grpcroom finds a room by name or slug.
+func grpcroom(snap *snapshot, name string) (location, bool) {
+	if _, ok := snap.icals[location(name)]; ok && name != "Alle" {
+		return location(name), true
+	}
+	if l, ok := roombyslug(slugify(name)); ok && l != "Alle" {
+		// private rooms are only in privateics
+		_, public := snap.icals[l]
+		return l, public
+	}
+	return "", false
+}
+
+// grpcwatchchanges streams the changes of every sync. With since_version,
+// the changes since that version are sent first, if it is recent enough.
+func grpcwatchchanges(w http.ResponseWriter, r *http.Request, req map[int]string) error {
+	// subscribe before looking at the schedule, so no sync slips through
+	_, _, changed := watchstate("")
+	snap := current()
+	base, version := snap.schedule, snap.version
+	if since := req[1]; since != "" && since != version {
+		old, ok, _ := watchstate(since)
+		if !ok {
+			return grpcerror{grpcfailedprecondition, "unknown or too old version " + strconv.Quote(since)}
+		}
+		for _, c := range diffschedules(old, base) {
+			if err := writegrpcmessage(w, c.proto(version)); err != nil {
+				return err
+			}
+		}
+	}
+	if err := http.NewResponseController(w).Flush(); err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-r.Context().Done():
+			return nil
+		case <-changed:
+		}
+		_, _, changed = watchstate("")
+		snap = current()
+		if snap.version == version {
+			continue
+		}
+		for _, c := range diffschedules(base, snap.schedule) {
+			if err := writegrpcmessage(w, c.proto(snap.version)); err != nil {
+				return err
+			}
+		}
+		base, version = snap.schedule, snap.version
+	}
+}
+
+func handlegrpc(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "gRPC only", http.StatusUnsupportedMediaType)
+		return
+	}
+	w.Header().Set("Content-Type", "application/grpc+proto")
+	w.Header().Add("Trailer", "Grpc-Status")
+	w.Header().Add("Trailer", "Grpc-Message")
+	w.WriteHeader(http.StatusOK)
+
+	err := func() error {
+		msg, err := readgrpcmessage(r.Body)
+		if err != nil {
+			return err
+		}
+		req, err := protostrings(msg)
+		if err != nil {
+			return grpcerror{grpcinvalidargument, err.Error()}
+		}
+		var resp []byte
+		switch strings.TrimPrefix(r.URL.Path, grpcprefix) {
+		case "ListEvents":
+			resp, err = grpclistevents(req)
+		case "GetRoomNowNext":
+			resp, err = grpcroomnownext(req)
+		case "WatchChanges":
+			return grpcwatchchanges(w, r, req)
+		default:
+			return grpcerror{grpcunimplemented, "unknown method " + r.URL.Path}
+		}
+		if err != nil {
+			return err
+		}
+		return writegrpcmessage(w, resp)
+	}()
+
+	code, message := grpcok, ""
+	var gerr grpcerror
+	if errors.As(err, &gerr) {
+		code, message = gerr.code, gerr.msg
+	} else if err != nil {
+		code, message = grpcinternal, err.Error()
+	}
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+	w.Header().Set("Grpc-Message", grpcpercentencode(message))
+}
+
+// grpcpercentencode escapes a status message as the gRPC spec requires.
+func grpcpercentencode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c >= ' ' && c <= '~' && c != '%' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var started = time.Now()
+
+// nominalinterval is the refresh interval at t without jitter.
+func nominalinterval(t time.Time) time.Duration {
+	minutes := conf().Interval
+	switch {
+	case t.After(gpnstart.Add(-time.Hour)) && t.Before(gpnstop):
+		minutes = conf().LiveInterval
+	case t.Before(gpnstart.Add(-24*time.Hour)) || t.After(gpnstop.Add(24*time.Hour)):
+		minutes = conf().IdleInterval
+	}
+	return time.Duration(minutes * float64(time.Minute))
+}
+
+// staleness reports how long ago the schedule was last synced, and whether
+// that is more than StaleIntervals refresh intervals.
+func staleness() (time.Duration, bool) {
+	last := currentsyncstatus().LastSync
+	if last.IsZero() {
+		last = started
+	}
+	age := now().Sub(last)
+	return age, conf().StaleIntervals > 0 && age > time.Duration(conf().StaleIntervals*float64(nominalinterval(now())))
+}
+
+func humanduration(d time.Duration) string {
+	s := strings.TrimSuffix(d.Round(time.Minute).String(), "0s")
+	if h, ok := strings.CutSuffix(s, "h0m"); ok {
+		return h + "h"
+	}
+	return s
+}
+
+func stalebanner(lang string) string {
+	age, stale := staleness()
+	if !stale {
+		return ""
+	}
+	return fmt.Sprintf(translate(lang, "stale"), humanduration(age))
+}
+
+func watchstaleness() {
+	wasstale := false
+	for range time.Tick(time.Minute) {
+		age, stale := staleness()
+		switch {
+		case stale && !wasstale:
+			log.Printf("ALERT: schedule is stale, last successful sync %s ago", humanduration(age))
+		case !stale && wasstale:
+			log.Println("schedule is fresh again")
+		}
+		wasstale = stale
+	}
+}
+
+func handlereadyz(w http.ResponseWriter, r *http.Request) {
+	if current().version == "" {
+		http.Error(w, "no schedule published yet", http.StatusServiceUnavailable)
+		return
+	}
+	if age, stale := staleness(); stale {
+		http.Error(w, "schedule last updated "+humanduration(age)+" ago", http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+func handlemetrics(w http.ResponseWriter, r *http.Request) {
+	age, stale := staleness()
+	s := currentsyncstatus()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintln(w, "# TYPE gpnsched_last_sync_timestamp_seconds gauge")
+	if !s.LastSync.IsZero() {
+		fmt.Fprintln(w, "gpnsched_last_sync_timestamp_seconds", s.LastSync.Unix())
+	}
+	fmt.Fprintln(w, "# TYPE gpnsched_sync_age_seconds gauge")
+	fmt.Fprintln(w, "gpnsched_sync_age_seconds", int(age.Seconds()))
+	fmt.Fprintln(w, "# TYPE gpnsched_stale gauge")
+	if stale {
+		fmt.Fprintln(w, "gpnsched_stale 1")
+	} else {
+		fmt.Fprintln(w, "gpnsched_stale 0")
+	}
+	fmt.Fprintln(w, "# TYPE gpnsched_events gauge")
+	fmt.Fprintln(w, "gpnsched_events", len(current().schedule))
+}
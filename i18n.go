@@ -0,0 +1,55 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"net/http"
+	"path"
+	"strings"
+)
+
+//go:embed i18n/*.json
+var embeddedcatalogs embed.FS
+
+var catalogs = loadcatalogs()
+
+func loadcatalogs() map[string]map[string]string {
+	ret := map[string]map[string]string{}
+	files, _ := embeddedcatalogs.ReadDir("i18n")
+	for _, f := range files {
+		buf, err := embeddedcatalogs.ReadFile("i18n/" + f.Name())
+		if err != nil {
+			panic(err)
+		}
+		catalog := map[string]string{}
+		if err := json.Unmarshal(buf, &catalog); err != nil {
+			panic(err)
+		}
+		ret[strings.TrimSuffix(f.Name(), path.Ext(f.Name()))] = catalog
+	}
+	return ret
+}
+
+func translate(lang, key string) string {
+	if s, ok := catalogs[lang][key]; ok {
+		return s
+	}
+	if s, ok := catalogs["en"][key]; ok {
+		return s
+	}
+	return key
+}
+
+func requestlanguage(r *http.Request) string {
+	if lang := r.URL.Query().Get("lang"); catalogs[lang] != nil {
+		return lang
+	}
+	for _, tag := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		tag, _, _ = strings.Cut(strings.TrimSpace(tag), ";")
+		tag, _, _ = strings.Cut(strings.ToLower(tag), "-")
+		if catalogs[tag] != nil {
+			return tag
+		}
+	}
+	return conf().Language
+}
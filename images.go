@@ -0,0 +1,148 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const maximagesize = 5 << 20
+
+var (
+	imagemutex  sync.Mutex
+	imageclient = &http.Client{Timeout: 30 * time.Second}
+)
+
+func imagekey(u string) string {
+	sum := sha256.Sum256([]byte(u))
+	return hex.EncodeToString(sum[:12])
+}
+
+// imagepath is where an upstream image is served from, or "" if image
+// proxying is off. Origin URLs are never handed out, so subscribers' clients
+// don't all end up hitting the origin host.
+func imagepath(u string) string {
+	if u == "" || conf().Images == "" {
+		return ""
+	}
+	return "/img/" + imagekey(u)
+}
+
+func (e *event) ImagePath() string {
+	return imagepath(e.Image)
+}
+
+func (e *event) SpeakerImagePath() string {
+	return imagepath(e.Speaker_image)
+}
+
+// ImageURL is the absolute URL for calendars, which needs BaseURL.
+func (e *event) ImageURL() string {
+	if p := e.ImagePath(); p != "" && conf().BaseURL != "" {
+		return strings.TrimSuffix(conf().BaseURL, "/") + p
+	}
+	return ""
+}
+
+// imageurls maps the keys of all images in the schedule to their origin, so
+// that /img/ only ever fetches those and can't be used as an open proxy.
+func imageurls(cs ...calendar) map[string]string {
+	ret := map[string]string{}
+	for _, t := range conf().Tracks {
+		if t.Icon != "" {
+			ret[imagekey(t.Icon)] = t.Icon
+		}
+	}
+	for _, c := range cs {
+		for _, e := range c {
+			for _, u := range []string{e.Image, e.Speaker_image} {
+				if u != "" {
+					ret[imagekey(u)] = u
+				}
+			}
+		}
+	}
+	return ret
+}
+
+func fetchimage(u string) ([]byte, error) {
+	resp, err := imageclient.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", u, resp.Status)
+	}
+	buf, err := io.ReadAll(io.LimitReader(resp.Body, maximagesize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) > maximagesize {
+		return nil, fmt.Errorf("%s: larger than %d bytes", u, maximagesize)
+	}
+	if !strings.HasPrefix(http.DetectContentType(buf), "image/") {
+		return nil, errors.New(u + ": not an image")
+	}
+	return buf, nil
+}
+
+func cachedimage(key, u string) ([]byte, error) {
+	path := filepath.Join(conf().Images, key)
+	if buf, err := os.ReadFile(path); err == nil {
+		return buf, nil
+	}
+	imagemutex.Lock()
+	defer imagemutex.Unlock()
+	if buf, err := os.ReadFile(path); err == nil {
+		return buf, nil
+	}
+	buf, err := fetchimage(u)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path+".tmp", buf, 0644); err != nil {
+		return nil, err
+	}
+	return buf, os.Rename(path+".tmp", path)
+}
+
+// prefetchimages fills the cache after a sync, so that the first visitors
+// don't have to wait for the origin.
+func prefetchimages(calendar) {
+	images := current().images
+	go func() {
+		for key, u := range images {
+			if _, err := cachedimage(key, u); err != nil {
+				log.Println("fetching image:", err)
+			}
+		}
+	}()
+}
+
+func handleimage(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/img/")
+	u, ok := current().images[key]
+	if !ok || conf().Images == "" {
+		http.NotFound(w, r)
+		return
+	}
+	buf, err := cachedimage(key, u)
+	if err != nil {
+		log.Println("fetching image:", err)
+		http.Error(w, "image not available", http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", http.DetectContentType(buf))
+	w.Header().Set("Cache-Control", "public, max-age=86400, immutable")
+	w.Write(buf)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+)
+
+// infobeamertalk is the talk layout read by the info-beamer
+// conference-room packages.
+type infobeamertalk struct {
+	ID        string   `json:"id"`
+	Title     string   `json:"title"`
+	Abstract  string   `json:"abstract"`
+	Place     string   `json:"place"`
+	Track     string   `json:"track"`
+	Lang      string   `json:"lang"`
+	Speakers  []string `json:"speakers"`
+	StartStr  string   `json:"start_str"`
+	EndStr    string   `json:"end_str"`
+	StartUnix int64    `json:"start_unix"`
+	EndUnix   int64    `json:"end_unix"`
+	Duration  int      `json:"duration"`
+}
+
+func newinfobeamertalk(e *event) infobeamertalk {
+	start, end := e.Starttime(), e.Endtime()
+	speakers := e.Speakers()
+	if speakers == nil {
+		speakers = []string{}
+	}
+	return infobeamertalk{
+		ID:        e.UID(),
+		Title:     e.Title,
+		Abstract:  e.Desc,
+		Place:     e.Place.String(),
+		Track:     e.Type,
+		Lang:      e.Language,
+		Speakers:  speakers,
+		StartStr:  start.In(loc).Format("15:04"),
+		EndStr:    end.In(loc).Format("15:04"),
+		StartUnix: start.Unix(),
+		EndUnix:   end.Unix(),
+		Duration:  int(end.Sub(start).Minutes()),
+	}
+}
+
+func handleinfobeamer(w http.ResponseWriter, r *http.Request) {
+	room := location(r.URL.Query().Get("room"))
+
+	c := current().schedule
+	talks := []infobeamertalk{}
+	for i := range c {
+		e := &c[i]
+		if e.Type == "break" || room != "" && e.Place != room {
+			continue
+		}
+		talks = append(talks, newinfobeamertalk(e))
+	}
+
+	sort.SliceStable(talks, func(i, j int) bool { return talks[i].StartUnix < talks[j].StartUnix })
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(talks)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"sync"
+)
+
+// lastgoodpages keeps the last successful rendering of recently requested
+// pages, to fall back to when a template fails, e.g. after a broken custom
+// template was loaded or on data it doesn't expect. Once maxcachedpages are
+// kept, the least recently used page makes room for a new one.
+var lastgoodpages = struct {
+	sync.Mutex
+	clock uint64
+	pages map[string]lastgoodpage
+}{pages: map[string]lastgoodpage{}}
+
+type lastgoodpage struct {
+	body []byte
+	used uint64
+}
+
+// lastgoodparams are the query parameters that change what a page shows.
+var lastgoodparams = []string{"tz", "day", "q"}
+
+// lastgoodkey identifies a page by what it is rendered from, so neither the
+// Host header nor unrelated query parameters multiply the entries.
+func lastgoodkey(r *http.Request, name, lang string) string {
+	key := name + "\x00" + lang + "\x00" + r.URL.Path
+	q := r.URL.Query()
+	for _, p := range lastgoodparams {
+		key += "\x00" + q.Get(p)
+	}
+	return key
+}
+
+func storelastgood(key string, page []byte) {
+	lastgoodpages.Lock()
+	defer lastgoodpages.Unlock()
+	if _, ok := lastgoodpages.pages[key]; !ok && len(lastgoodpages.pages) >= maxcachedpages {
+		oldest, used := "", uint64(math.MaxUint64)
+		for k, p := range lastgoodpages.pages {
+			if p.used < used {
+				oldest, used = k, p.used
+			}
+		}
+		delete(lastgoodpages.pages, oldest)
+	}
+	lastgoodpages.clock++
+	lastgoodpages.pages[key] = lastgoodpage{body: page, used: lastgoodpages.clock}
+}
+
+func lastgood(key string) ([]byte, bool) {
+	lastgoodpages.Lock()
+	defer lastgoodpages.Unlock()
+	p, ok := lastgoodpages.pages[key]
+	if ok {
+		lastgoodpages.clock++
+		p.used = lastgoodpages.clock
+		lastgoodpages.pages[key] = p
+	}
+	return p.body, ok
+}
+
+// withbanner puts a warning at the top of a rendered page.
+func withbanner(page []byte, banner string) []byte {
+	i := 0
+	if b := bytes.Index(page, []byte("<body")); b >= 0 {
+		if end := bytes.IndexByte(page[b:], '>'); end >= 0 {
+			i = b + end + 1
+		}
+	}
+	p := []byte("\n<p class=\"stale\">" + template.HTMLEscapeString(banner) + "</p>")
+	return slices.Concat(page[:i], p, page[i:])
+}
+
+// writepage sends a rendered page. If rendering failed, nothing of it is
+// sent; the error is logged and the last good version of the page is served
+// with a warning instead, or a plain error if there is none.
+func writepage(w http.ResponseWriter, r *http.Request, name, lang string, page []byte, err error) {
+	key := lastgoodkey(r, name, lang)
+	if err == nil {
+		storelastgood(key, page)
+	} else {
+		log.Printf("%s rendering %s: %v", requestid(r), loggeduri(r), err)
+		old, ok := lastgood(key)
+		if !ok {
+			http.Error(w, "this page could not be rendered", http.StatusInternalServerError)
+			return
+		}
+		page = withbanner(old, translate(lang, "renderfailed"))
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(page)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"strings"
+)
+
+type tlsconfig struct {
+	Cert string
+	Key  string
+}
+
+type listener struct {
+	Addr string
+	TLS  tlsconfig
+	// Serve selects the handler set: all (the default), public, admin, or
+	// redirect, which sends every request to Redirect.
+	Serve    string
+	Redirect string
+}
+
+var listenerkinds = []string{"", "all", "public", "admin", "redirect"}
+
+func listeners() []listener {
+	if len(conf().Listeners) > 0 {
+		return conf().Listeners
+	}
+	return []listener{{Addr: conf().Listen, TLS: conf().TLS}}
+}
+
+func adminpath(p string) bool {
+	return strings.HasPrefix(p, "/admin/") || p == "/metrics"
+}
+
+func onlypaths(h http.Handler, allow func(string) bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !allow(r.URL.Path) {
+			http.NotFound(w, r)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func (l listener) handler(mux http.Handler) http.Handler {
+	switch l.Serve {
+	case "redirect":
+		target := strings.TrimSuffix(l.Redirect, "/")
+		return withrequestid(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, target+r.URL.RequestURI(), http.StatusMovedPermanently)
+		}))
+	case "public":
+		mux = onlypaths(mux, func(p string) bool { return !adminpath(p) })
+	case "admin":
+		mux = onlypaths(mux, func(p string) bool { return adminpath(p) || p == "/readyz" || p == "/version" })
+	}
+	return withrequestid(withrecover(withcleanpath(withprotection(withversion(mux)))))
+}
+
+func (l listener) listenandserve(mux http.Handler) error {
+	s := newserver(l.Addr, l.handler(mux))
+	if l.TLS.Cert != "" {
+		if conf().Admin.ClientCA != "" {
+			// certificates are optional on the handshake, so that the public
+			// pages keep working; adminauth insists on one
+			pool, err := loadclientca(conf().Admin.ClientCA)
+			if err != nil {
+				return err
+			}
+			s.TLSConfig = &tls.Config{ClientAuth: tls.VerifyClientCertIfGiven, ClientCAs: pool}
+		}
+		return s.ListenAndServeTLS(l.TLS.Cert, l.TLS.Key)
+	}
+	return s.ListenAndServe()
+}
+
+// serve runs all configured listeners and returns when the first one fails.
+func serve(mux http.Handler) error {
+	ls := listeners()
+	errs := make(chan error, len(ls))
+	for _, l := range ls {
+		go func() {
+			errs <- l.listenandserve(mux)
+		}()
+	}
+	return <-errs
+}
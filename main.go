@@ -3,85 +3,123 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"cmp"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"html/template"
 	"io"
+	"log"
+	"maps"
+	"math/rand/v2"
 	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
-	"text/template"
 	"time"
+	"unicode/utf8"
 )
 
 var (
-	CRLF       = []byte{'\r', '\n'}
-	CRLFSP     = []byte{'\r', '\n', ' '}
-	loc, _     = time.LoadLocation("Europe/Berlin")
-	gpnstart   = time.Date(2013, 05, 30, 17, 23, 0, 0, loc)
-	gpnstop    = time.Date(2013, 06, 02, 15, 30, 0, 0, loc)
-	icals      = map[location][]byte{}
-	icalsmutex = sync.RWMutex{}
+	CRLF     = []byte{'\r', '\n'}
+	CRLFSP   = []byte{'\r', '\n', ' '}
+	loc      = mustloadlocation("Europe/Berlin")
+	gpnstart = time.Date(2013, 05, 30, 17, 23, 0, 0, loc)
+	gpnstop  = time.Date(2013, 06, 02, 15, 30, 0, 0, loc)
+	now      = time.Now
 )
 
-func parsegpntime(t string, fallback time.Time) time.Time {
-	var year, month, day, hour, min int
-	n, err := fmt.Sscanf(t, "%04d%02d%02d-%02d%02d", &year, &month, &day, &hour, &min)
-	if err != nil || n != 5 {
+// parsegpntime parses the upstream YYYYMMDD-hhmm format.
+func parsegpntime(t string, fallback time.Time, tz *time.Location) time.Time {
+	if len(t) < 13 || t[8] != '-' {
 		return fallback
 	}
-	return time.Date(year, time.Month(month), day, hour, min, 0, 0, loc)
+	var fields [5]int
+	for i, span := range [5][2]int{{0, 4}, {4, 6}, {6, 8}, {9, 11}, {11, 13}} {
+		for _, c := range t[span[0]:span[1]] {
+			if c < '0' || c > '9' {
+				return fallback
+			}
+			fields[i] = fields[i]*10 + int(c-'0')
+		}
+	}
+	return time.Date(fields[0], time.Month(fields[1]), fields[2], fields[3], fields[4], 0, 0, tz)
+}
+
+var zones = sync.Map{}
+
+// mustloadlocation refuses to start without the schedule's time zone, as
+// falling back to UTC would silently shift every event.
+func mustloadlocation(name string) *time.Location {
+	l, err := time.LoadLocation(name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "loading time zone %s: %v\nbuilt with -tags notzdata on a system without tzdata?\n", name, err)
+		os.Exit(1)
+	}
+	return l
+}
+
+func zone(name string) (*time.Location, error) {
+	if name == "" {
+		return loc, nil
+	}
+	if tz, ok := zones.Load(name); ok {
+		return tz.(*time.Location), nil
+	}
+	tz, err := time.LoadLocation(name)
+	if err != nil {
+		return loc, err
+	}
+	zones.Store(name, tz)
+	return tz, nil
 }
 
 type BreakLongLineWriter struct {
 	w      io.Writer
-	buf    []byte
 	maxlen int
 	pos    int
+	line   bytes.Buffer
 }
 
 func NewBreakLongLineWriter(w io.Writer, linelength int) io.Writer {
-	return &BreakLongLineWriter{w: w, buf: []byte{}, maxlen: linelength, pos: 0}
+	return &BreakLongLineWriter{w: w, maxlen: linelength}
 }
 
+// Write treats p as complete lines and folds them so that no physical line
+// reaches maxlen bytes, without splitting UTF-8 sequences.
 func (b *BreakLongLineWriter) Write(p []byte) (int, error) {
-	if len(b.buf) == 0 {
-		b.buf = p
-	} else {
-		b.buf = append(b.buf, p...)
-	}
-	for len(b.buf) > 0 {
-		adv, line, _ := bufio.ScanLines(b.buf, true)
-		var n int
+	for rest := p; len(rest) > 0; {
+		adv, line, _ := bufio.ScanLines(rest, true)
 		for len(line) > 0 {
-			adv, tok, _ := bufio.ScanRunes(line, false)
-			if tok == nil {
-				break
+			n := 0
+			for n < len(line) {
+				_, size := utf8.DecodeRune(line[n:])
+				if b.pos+n+size >= b.maxlen && (n > 0 || b.pos > 1) {
+					break
+				}
+				n += size
 			}
-
-			if b.pos+adv >= b.maxlen {
+			if _, err := b.w.Write(line[:n]); err != nil {
+				return len(p) - len(rest), err
+			}
+			b.pos += n
+			line = line[n:]
+			if len(line) > 0 {
 				b.w.Write(CRLFSP)
 				b.pos = 1
 			}
-
-			c, err := b.w.Write(tok)
-			if err != nil {
-				return len(p), err
-			}
-			b.pos += c
-			n += c
-			line = line[c:]
 		}
-		if n == 0 {
-			b.buf = append([]byte{}, b.buf...)
-			break
-		}
-		b.buf = b.buf[adv:]
 		if _, err := b.w.Write(CRLF); err != nil {
-			return n, err
+			return len(p) - len(rest), err
 		}
 		b.pos = 0
+		rest = rest[adv:]
 	}
 	return len(p), nil
 }
@@ -89,37 +127,104 @@ func (b *BreakLongLineWriter) Write(p []byte) (int, error) {
 type location string
 
 func (l location) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	fmt.Println(l)
-	icalsmutex.RLock()
+	if !authorized(r, l) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	snap := current()
+	ical, ok := snap.icals[l]
+	_, private := conf().Private[l]
+	if private {
+		ical, ok = snap.privateics[l]
+	}
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if q := r.URL.Query(); q.Has("duration") || q.Has("times") || q.Has("day") || q.Has("exclude") {
+		c := snap.schedule.Room(l)
+		if private {
+			c = snap.private[l]
+		}
+		if q.Has("day") {
+			day, err := parseday(q.Get("day"))
+			if err != nil {
+				http.Error(w, "day: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			c = c.Day(day)
+		}
+		if q.Has("exclude") {
+			c = c.Exclude(strings.Split(q.Get("exclude"), ","))
+		}
+		w.Header().Add("Content-Type", "text/calendar")
+		if err := c.WriteICal(newflushwriter(w, r), requesticaloptions(q)); err != nil {
+			log.Printf("%s streaming %s: %v", requestid(r), l, err)
+		}
+		return
+	}
+
 	w.Header().Add("Content-Type", "text/calendar")
-	w.Header().Add("Content-Length", fmt.Sprintf("%d", len(icals[l])))
-	w.Write(icals[l])
-	icalsmutex.RUnlock()
+	w.Header().Add("Content-Length", fmt.Sprintf("%d", len(ical)))
+	newflushwriter(w, r).Write(ical)
 }
+
+func requesticaloptions(q url.Values) icaloptions {
+	opt := defaulticaloptions()
+	if d := q.Get("duration"); d != "" {
+		opt.duration, _ = strconv.ParseBool(d)
+	}
+	if t := q.Get("times"); t != "" {
+		opt.times = t
+	}
+	return opt
+}
+
 func (l location) String() string {
 	return string(l)
 }
 
+func (l location) C3nav() string {
+	id := l.Metadata().C3nav
+	if id == "" || conf().C3navURL == "" {
+		return ""
+	}
+	return strings.TrimRight(conf().C3navURL, "/") + "/l/" + url.PathEscape(id) + "/"
+}
+
 type event struct {
-	Confirmed   string
-	Start       string
-	End         string
-	Type        string
-	Title       string
-	Speaker     string
-	Affiliation string
-	Desc        string
-	Long_desc   string
-	Link        string
-	Place       location
+	Confirmed     string
+	Start         string
+	End           string
+	Type          string
+	Title         string
+	Speaker       string
+	Affiliation   string
+	Desc          string
+	Long_desc     string
+	Link          string
+	Place         location
+	Do_not_record bool
+	Language      string
+	Series        string
+	Timezone      string
+	Image         string
+	Speaker_image string
+	// Source names the configured source the event was fetched from.
+	Source string `json:",omitempty"`
+}
+
+func (e *event) Zone() *time.Location {
+	tz, _ := zone(e.Timezone)
+	return tz
 }
 
 func (e *event) Starttime() time.Time {
-	return parsegpntime(e.Start, gpnstart)
+	return parsegpntime(e.Start, gpnstart, e.Zone())
 }
 
 func (e *event) Endtime() time.Time {
-	return parsegpntime(e.End, e.Starttime())
+	return parsegpntime(e.End, e.Starttime(), e.Zone())
 }
 
 func (e *event) Titlestring() (ret string) {
@@ -133,16 +238,29 @@ func (e *event) Titlestring() (ret string) {
 	return
 }
 
-func (e *event) Description() (ret string) {
+func (e *event) Description() string {
+	return e.DescriptionIn(conf().Language)
+}
+
+func (e *event) DescriptionIn(lang string) (ret string) {
 	if e.Long_desc != "" {
 		ret = e.Long_desc
 	} else if e.Desc != "" {
 		ret = e.Desc
 	} else {
-		ret = "No Description"
+		ret = translate(lang, "nodescription")
 	}
 	if e.Link != "" {
-		ret = "\n\n" + e.Link
+		ret += "\n\n" + e.Link
+	}
+	if f := e.Feedback(); f != "" {
+		ret += "\n\n" + translate(lang, "feedback") + ": " + f
+	}
+	if nav := e.Place.C3nav(); nav != "" {
+		ret += "\n\nc3nav: " + nav
+	}
+	if e.Do_not_record {
+		ret += "\n\n" + translate(lang, "norecording")
 	}
 	return
 }
@@ -156,112 +274,605 @@ func (e *event) UID() (ret string) {
 	return hex.EncodeToString(hash.Sum([]byte{}))
 }
 
+func (e *event) languageparam() string {
+	lang := strings.Map(func(r rune) rune {
+		if r == '-' || 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' {
+			return r
+		}
+		return -1
+	}, e.Language)
+	if lang == "" {
+		return ""
+	}
+	return ";LANGUAGE=" + lang
+}
+
 func icaldatetime(t time.Time) string {
-	year, month, day := t.UTC().Date()
-	hour, min, sec := t.UTC().Clock()
-	return fmt.Sprintf("%04d%02d%02dT%02d%02d%02dZ", year, month, day, hour, min, sec)
+	return t.UTC().Format("20060102T150405Z")
 }
 
-var icalescape = strings.NewReplacer(
-	"\\", "\\\\",
-	"\n", "\\n",
-	";", "\\;",
-	",", "\\,",
-).Replace
+var (
+	icalescaper = strings.NewReplacer(
+		"\\", "\\\\",
+		"\n", "\\n",
+		";", "\\;",
+		",", "\\,",
+	)
+	icalescape = icalescaper.Replace
+)
 
 func icalformatline(w io.Writer, key, value string) {
-	fmt.Fprintf(w, "%s:%s\r\n", key, icalescape(value))
+	b, ok := w.(*BreakLongLineWriter)
+	if !ok {
+		fmt.Fprintf(w, "%s:%s\r\n", key, icalescape(value))
+		return
+	}
+	b.line.Reset()
+	b.line.WriteString(key)
+	b.line.WriteByte(':')
+	icalescaper.WriteString(&b.line, value)
+	b.Write(b.line.Bytes())
 }
 
-func (e *event) VEVENT(w io.Writer) {
+func icalduration(d time.Duration) string {
+	ret := "P"
+	if d < 0 {
+		ret, d = "-P", -d
+	}
+	if days := d / (24 * time.Hour); days > 0 {
+		ret += fmt.Sprintf("%dD", days)
+		d -= days * 24 * time.Hour
+	}
+	if d == 0 && ret != "P" && ret != "-P" {
+		return ret
+	}
+	ret += "T"
+	if h := d / time.Hour; h > 0 {
+		ret += fmt.Sprintf("%dH", h)
+		d -= h * time.Hour
+	}
+	if m := d / time.Minute; m > 0 {
+		ret += fmt.Sprintf("%dM", m)
+		d -= m * time.Minute
+	}
+	if s := d / time.Second; s > 0 || strings.HasSuffix(ret, "T") {
+		ret += fmt.Sprintf("%dS", s)
+	}
+	return ret
+}
+
+type icaloptions struct {
+	duration   bool
+	recurrence bool
+	times      string
+	related    map[string][]string
+	rrules     map[string]string
+	alarm      time.Duration
+	stamp      string
+}
+
+func defaulticaloptions() icaloptions {
+	return icaloptions{duration: conf().Duration, recurrence: conf().Recurrence, times: conf().Times, stamp: current().stamp}
+}
+
+func (e *event) VEVENT(w io.Writer, opt icaloptions) {
+	uid, start, end, tz, lang := e.UID(), e.Starttime(), e.Endtime(), e.Zone(), e.languageparam()
+	stamp := opt.stamp
+	if stamp == "" {
+		stamp = defaultstamp()
+	}
 	icalformatline(w, "BEGIN", "VEVENT")
-	icalformatline(w, "DTSTAMP", icaldatetime(time.Now()))
-	icalformatline(w, "DTSTART", icaldatetime(e.Starttime()))
-	icalformatline(w, "DTEND", icaldatetime(e.Endtime()))
-	icalformatline(w, "SUMMARY", e.Titlestring())
-	icalformatline(w, "DESCRIPTION", e.Description())
-	icalformatline(w, "LOCATION", e.Place.String())
-	icalformatline(w, "UID", e.UID())
+	icalformatline(w, "DTSTAMP", stamp)
+	icaltime(w, "DTSTART", start, tz, opt.times)
+	if opt.duration {
+		icalformatline(w, "DURATION", icalduration(end.Sub(start)))
+	} else {
+		icaltime(w, "DTEND", end, tz, opt.times)
+	}
+	icalformatline(w, "SUMMARY"+lang, e.Titlestring())
+	icalformatline(w, "DESCRIPTION"+lang, e.Description())
+	room := e.Place.Metadata()
+	if nav := e.Place.C3nav(); nav != "" {
+		icalformatline(w, "LOCATION;ALTREP=\""+nav+"\"", room.Address())
+	} else {
+		icalformatline(w, "LOCATION", room.Address())
+	}
+	if geo := room.Geo(); geo != "" {
+		fmt.Fprintf(w, "GEO:%s\r\n", geo)
+	}
+	icalformatline(w, "UID", uid)
+	if t := e.Track(); t.ID != "" {
+		icalformatline(w, "CATEGORIES", t.Name)
+		if t.Color != "" {
+			icalformatline(w, "COLOR", t.Color)
+		}
+		icalformatline(w, "X-GPN-TRACK", t.ID)
+	}
+	icalformatline(w, "X-GPN-EVENT-ID", uid)
+	if e.Confirmed != "" {
+		icalformatline(w, "X-GPN-CONFIRMED", e.Confirmed)
+	}
+	if e.Source != "" {
+		icalformatline(w, "X-GPN-SOURCE", e.Source)
+	}
+	if u := e.ImageURL(); u != "" {
+		icalformatline(w, "IMAGE;VALUE=URI;DISPLAY=BADGE", u)
+		icalformatline(w, "ATTACH", u)
+	}
+	if rule, ok := opt.rrules[uid]; ok {
+		fmt.Fprintf(w, "RRULE:%s\r\n", rule)
+	}
+	for _, related := range opt.related[uid] {
+		icalformatline(w, "RELATED-TO;RELTYPE=SIBLING", related)
+	}
+	if p, ok := conf().Types[e.Type]; ok {
+		if p.Transp != "" {
+			icalformatline(w, "TRANSP", strings.ToUpper(p.Transp))
+		}
+		if p.Class != "" {
+			icalformatline(w, "CLASS", strings.ToUpper(p.Class))
+		}
+		if p.Priority != 0 {
+			icalformatline(w, "PRIORITY", strconv.Itoa(p.Priority))
+		}
+	}
+	if e.Do_not_record {
+		icalformatline(w, "X-GPN-NO-RECORDING", "TRUE")
+	}
+	if opt.alarm > 0 {
+		icalformatline(w, "BEGIN", "VALARM")
+		icalformatline(w, "ACTION", "DISPLAY")
+		icalformatline(w, "DESCRIPTION", e.Title)
+		icalformatline(w, "TRIGGER", icalduration(-opt.alarm))
+		icalformatline(w, "END", "VALARM")
+	}
 	icalformatline(w, "END", "VEVENT")
 }
 
 type calendar []event
 
 func (c calendar) ICal() []byte {
-	var buf bytes.Buffer
-	w := NewBreakLongLineWriter(&buf, 75)
+	return c.ICalWith(defaulticaloptions())
+}
+
+// sorted returns the events ordered by start and UID, so that the same
+// schedule always renders byte for byte the same.
+func (c calendar) sorted() calendar {
+	type key struct {
+		start time.Time
+		uid   string
+		i     int
+	}
+	keys := make([]key, len(c))
+	for i := range c {
+		keys[i] = key{c[i].Starttime(), c[i].UID(), i}
+	}
+	slices.SortFunc(keys, func(a, b key) int {
+		return cmp.Or(a.start.Compare(b.start), strings.Compare(a.uid, b.uid), a.i-b.i)
+	})
+	ret := make(calendar, len(c))
+	for j, k := range keys {
+		ret[j] = c[k.i]
+	}
+	return ret
+}
+
+func (c calendar) ICalWith(opt icaloptions) []byte {
+	buf := getbuffer()
+	defer putbuffer(buf)
+	buf.Grow(1024 * len(c))
+	c.WriteICal(buf, opt)
+	return bytes.Clone(buf.Bytes())
+}
+
+// WriteICal streams the calendar to out, so large calendars needn't be
+// rendered into memory first. It stops at the first error writing to out.
+func (c calendar) WriteICal(out io.Writer, opt icaloptions) error {
+	c = c.sorted()
+	if opt.related == nil {
+		opt.related = c.Related()
+	}
+	if opt.recurrence && opt.rrules == nil {
+		c, opt.rrules = c.Recurring()
+		c = c.sorted()
+	}
+	if opt.stamp == "" {
+		opt.stamp = defaultstamp()
+	}
+	ew := &errwriter{w: out}
+	w := getlinewriter(ew, 75)
+	defer putlinewriter(w)
 	icalformatline(w, "BEGIN", "VCALENDAR")
 	icalformatline(w, "VERSION", "2.0")
 	icalformatline(w, "PRODID", "pff")
+	icalformatline(w, "METHOD", "PUBLISH")
+	if opt.times == icaltimestzid {
+		c.VTIMEZONEs(w)
+	}
 
 	for _, e := range c {
-		e.VEVENT(w)
+		if ew.err != nil {
+			return ew.err
+		}
+		e.VEVENT(w, opt)
 	}
 
 	icalformatline(w, "END", "VCALENDAR")
-	return buf.Bytes()
+	return ew.err
 }
 
-const htmltmpl = "" +
-	`
-<head>
-<title>Fahrplaene</title>
-</head>
-<body>
-{{range $room, $discard := . }}
-<a href="/{{$room}}">{{$room}}</a><br/>
-{{end}}
-</body>
-`
+type indexpage struct {
+	Rooms       []indexroom
+	Start, Stop time.Time
+	Countdown   time.Duration
+}
 
-func synccalendars() {
-	ticker := time.NewTicker(5 * time.Minute)
-	for ; ; <-ticker.C {
-		resp, err := http.Get("http://bl0rg.net/~andi/gpn13-fahrplan.json")
+// indexroom is a room's subscription along with what is on there right now.
+type indexroom struct {
+	subscription
+	Now  []apievent
+	Next *apievent
+}
+
+func indexrooms(r *http.Request, snap *snapshot) (ret []indexroom) {
+	running := map[location]roomnow{}
+	for _, n := range nownext(snap.schedule, now(), loc) {
+		running[n.Room] = n
+	}
+	for _, s := range subscriptions(r, snap) {
+		ret = append(ret, indexroom{s, running[s.Name].Now, running[s.Name].Next})
+	}
+	return
+}
+
+// Live is whether anything is running, so the page keeps itself current.
+func (p indexpage) Live() bool {
+	return slices.ContainsFunc(p.Rooms, func(r indexroom) bool { return len(r.Now) > 0 })
+}
+
+func (p indexpage) Before() bool {
+	return p.Countdown > 0
+}
+
+func (p indexpage) CountdownString() string {
+	d := p.Countdown.Truncate(time.Minute)
+	days := d / (24 * time.Hour)
+	d -= days * 24 * time.Hour
+	return fmt.Sprintf("%dd %02dh %02dm", days, d/time.Hour, (d%time.Hour)/time.Minute)
+}
+
+type subscription struct {
+	Name   location
+	Room   roommetadata
+	Webcal template.URL
+	URL    string
+}
+
+func baseurl(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+func webcalurl(r *http.Request, l location) string {
+	return "webcal://" + r.Host + roompath(l)
+}
+
+func subscriptions(r *http.Request, snap *snapshot) (ret []subscription) {
+	for room := range snap.icals {
+		ret = append(ret, subscription{
+			Name:   room,
+			Room:   room.Metadata(),
+			Webcal: template.URL(webcalurl(r, room)),
+			URL:    baseurl(r) + roompath(room),
+		})
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+	return
+}
+
+func openschedule(source string) (io.ReadCloser, error) {
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		resp, err := http.Get(source)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
-		defer resp.Body.Close()
+		return resp.Body, nil
+	}
+	return os.Open(source)
+}
 
-		events := calendar{}
-		dec := json.NewDecoder(resp.Body)
-		err = dec.Decode(&events)
-		if err != nil {
-			panic(err)
+func fetchschedule(source string) (calendar, error) {
+	r, err := openschedule(source)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	events := calendar{}
+	dec := json.NewDecoder(r)
+	if err := dec.Decode(&events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+func rendercalendars(events calendar, opt icaloptions) map[location][]byte {
+	rendered, _ := renderchanged(roomcalendars(events), opt, nil, nil)
+	return rendered
+}
+
+// prepareschedule turns fetched events into what is published: overrides
+// and filters applied, breaks added and private rooms split off.
+func prepareschedule(events calendar) (calendar, map[location]calendar) {
+	return splitprivate(addbreaks(filterevents(applyoverrides(events))))
+}
+
+func publish(events calendar) error {
+	events, hidden := prepareschedule(events)
+	version := events.Version()
+	// DTSTAMP only moves when the schedule changes, so unchanged calendars
+	// stay byte for byte the same across syncs.
+	stamp := defaultstamp()
+	if prev := current(); prev.version == version && prev.stamp != "" {
+		stamp = prev.stamp
+	}
+	opt := defaulticaloptions()
+	opt.stamp = stamp
+	prev := current()
+	rendered, versions := renderchanged(roomcalendars(events), opt, prev.icals, prev.roomversions)
+	pdf := prev.timetable
+	if pdf == nil || versions["Alle"] != prev.roomversions["Alle"] {
+		pdf = rendertimetable(events)
+	}
+	renderedprivate, privateversions := renderchanged(hidden, opt, prev.privateics, prev.roomversions)
+	maps.Copy(versions, privateversions)
+
+	if conf().Strict {
+		valid := true
+		for _, calendars := range []map[location][]byte{rendered, renderedprivate} {
+			for room, ical := range calendars {
+				for _, p := range validateical(ical) {
+					log.Printf("invalid calendar %s: %s", room, p)
+					valid = false
+				}
+			}
 		}
+		if !valid {
+			return errors.New("strict mode: not publishing invalid calendars")
+		}
+	}
+
+	signatures := signcalendars(version, rendered, renderedprivate)
+	published.Store(&snapshot{
+		schedule:     events,
+		index:        newsearchindex(events),
+		icals:        rendered,
+		private:      hidden,
+		privateics:   renderedprivate,
+		roomversions: versions,
+		timetable:    pdf,
+		version:      version,
+		stamp:        stamp,
+		signatures:   signatures,
+		checksums:    checksums(rendered, signatures, pdf),
+		images:       imageurls(events, slices.Concat(slices.Collect(maps.Values(hidden))...)),
+	})
+	announcesnapshot(current())
+	return nil
+}
 
-		builder := map[location]calendar{}
-		for _, e := range events {
-			builder[e.Place] = append(builder[e.Place], e)
+func refreshinterval(now time.Time) time.Duration {
+	d := nominalinterval(now)
+	if conf().Jitter > 0 {
+		d += time.Duration((rand.Float64()*2 - 1) * conf().Jitter * float64(d))
+	}
+	return d
+}
+
+var synchooks []func(calendar)
+
+func synccalendars() {
+	initsources()
+	published := false
+	if events, err := loadcache(); err != nil {
+		log.Println("loading cache:", err)
+	} else if events != nil {
+		if err := publish(events); err != nil {
+			log.Println(err)
 		}
+		published = true
+	}
 
-		icalsmutex.Lock()
-		icals = map[location][]byte{}
-		icals["Alle"] = events.ICal()
-		for room, events := range builder {
-			if room != "" {
-				icals[room] = events.ICal()
+	for ; ; waitrefresh() {
+		events, err := fetchsources()
+		if err != nil {
+			log.Println("fetching schedule:", err)
+			setsyncstatus(err)
+			if !published && fallbackschedule != nil {
+				log.Println("using embedded fallback schedule")
+				if err := publishfallback(); err != nil {
+					log.Println(err)
+				}
+				published = true
 			}
+			continue
+		}
+		published = true
+		if err := publish(events); err != nil {
+			log.Println(err)
+			setsyncstatus(err)
+			continue
+		}
+		setsyncstatus(nil)
+		if err := savecache(events); err != nil {
+			log.Println("saving cache:", err)
+		}
+
+		for _, hook := range synchooks {
+			hook(current().schedule)
 		}
-		icalsmutex.Unlock()
 	}
 }
 
 func handle(w http.ResponseWriter, r *http.Request) {
-	if path := r.URL.Path; path == "/" {
-		icalsmutex.RLock()
-		tmpl := template.Must(template.New("html").Parse(htmltmpl))
-		tmpl.Execute(w, icals)
-		icalsmutex.RUnlock()
+	if path := r.URL.Path; path == "/" && r.Method == "PROPFIND" {
+		handledavroot(w, r)
+	} else if path == "/" {
+		// The countdown and the running talks are rendered server side, so
+		// refresh them every minute.
+		rendercached(w, r, "index.html", time.Minute, func(snap *snapshot) any {
+			return indexpage{
+				Rooms:     indexrooms(r, snap),
+				Start:     gpnstart,
+				Stop:      gpnstop,
+				Countdown: gpnstart.Sub(now()),
+			}
+		})
 	} else {
-		location(path[1:]).ServeHTTP(w, r)
+		redirectroom(w, r, path)
 	}
 }
 
-func main() {
+func withversion(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if v := current().version; v != "" {
+			w.Header().Set("X-Schedule-Version", v)
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func servecmd(args []string) int {
+	fs, configfile := configflags("serve")
+	listen := fs.String("listen", "", "listen address (default from config)")
+	dry := fs.Bool("dry-run", false, "fetch and summarize the schedule, then exit")
+	current := fs.String("current", "", "schedule url or file to compare against in dry-run mode")
+	parseflags(fs, configfile, args)
+	if *listen != "" {
+		conf().Listen = *listen
+		conf().Listeners = nil
+	}
+	if *dry {
+		return dryrun(*current)
+	}
+
+	if err := loadtemplates(); err != nil {
+		panic(err)
+	}
+	if conf().TemplateReload > 0 {
+		go watchtemplates(time.Duration(conf().TemplateReload) * time.Second)
+	}
+
+	if conf().Google.Calendar != "" {
+		g, err := newgooglepusher(conf().Google.Credentials, conf().Google.Calendar)
+		if err != nil {
+			panic(err)
+		}
+		synchooks = append(synchooks, g.push)
+	}
+
+	if conf().CalDAVPush.URL != "" {
+		synchooks = append(synchooks, newcaldavpusher(conf().CalDAVPush.URL, conf().CalDAVPush.User, conf().CalDAVPush.Password).push)
+	}
+
+	if conf().XMPP.JID != "" {
+		notifiers = append(notifiers, newxmppnotifier(conf().XMPP.JID, conf().XMPP.Password, conf().XMPP.Server, conf().XMPP.Nick, conf().XMPP.Rooms))
+	}
+	if conf().Telegram.Token != "" {
+		t, err := newtelegrambot(conf().Telegram.Token, conf().Telegram.Subscribers)
+		if err != nil {
+			panic(err)
+		}
+		notifiers = append(notifiers, t)
+		go t.run()
+	}
+	// the announcer is installed even without notifiers, so that ones
+	// added by a config reload get announcements too
+	a := &announcer{}
+	synchooks = append(synchooks, a.changes)
+	if len(notifiers) > 0 && conf().NotifyLead > 0 {
+		go a.upcoming(time.Duration(conf().NotifyLead * float64(time.Minute)))
+	}
+	if configpath != "" && conf().ConfigReload > 0 {
+		go watchconfig(configpath, time.Duration(conf().ConfigReload)*time.Second)
+	}
+
+	if conf().Images != "" {
+		if err := os.MkdirAll(conf().Images, 0755); err != nil {
+			panic(err)
+		}
+		synchooks = append(synchooks, prefetchimages)
+	}
+
 	go synccalendars()
+	go watchstaleness()
+	go watchfeedback()
+	go watchsubscribers()
 	http.HandleFunc("/", handle)
-	if err := http.ListenAndServe(":8000", nil); err != nil {
+	for _, ep := range apiendpoints {
+		http.HandleFunc(ep.Path, ep.Handler)
+	}
+	http.HandleFunc("/api/openapi.json", handleopenapi)
+	http.HandleFunc("/conflicts", handleconflicts)
+	http.HandleFunc("/speakers", handlespeakers)
+	http.HandleFunc("/speakers/", handlespeakers)
+	http.HandleFunc("/speakers.vcf", handlevcards)
+	http.HandleFunc("/tracks", handletracks)
+	http.HandleFunc("/tracks/", handletracks)
+	http.HandleFunc("/events/", handleevent)
+	http.HandleFunc("/qr/", handleqr)
+	http.Handle("/static/", staticfiles)
+	http.HandleFunc("/caldav/", handlecaldav)
+	http.HandleFunc("/.well-known/caldav", handlewellknowncaldav)
+	http.HandleFunc("/dav/", handledav)
+	http.HandleFunc("/freebusy/", handlefreebusy)
+	http.HandleFunc("/export.xlsx", handlexlsx)
+	http.HandleFunc("/all-calendars.zip", handlebundle)
+	http.HandleFunc("/checksums", handlechecksums)
+	http.HandleFunc("/upstream.json", handleupstream)
+	http.HandleFunc("/img/", handleimage)
+	http.HandleFunc("/schedule.pdf", handletimetable)
+	http.HandleFunc("/doorsign/", handledoorsign)
+	http.HandleFunc("/days/", handledays)
+	http.HandleFunc("/rooms/", handlerooms)
+	http.Handle("/all.ics", location("Alle"))
+	http.HandleFunc("/all.ics.asc", location("Alle").servesignature)
+	http.HandleFunc("/feeds/", handlefeeds)
+	http.HandleFunc("/angels/", handleangels)
+	http.HandleFunc("/schedule.json", handleschedulejson)
+	http.HandleFunc("/schedule.xml", handleschedulexml)
+	http.HandleFunc("/readyz", handlereadyz)
+	http.HandleFunc("/version", handleversion)
+	http.HandleFunc("/metrics", handlemetrics)
+	http.HandleFunc("/admin/", adminauth(handleadmin))
+	http.HandleFunc("/admin/overrides", adminauth(handleoverrides))
+	http.HandleFunc("/admin/anomalies", adminauth(handleanomalies))
+	http.HandleFunc(grpcprefix, handlegrpc)
+	if err := serve(http.DefaultServeMux); err != nil {
 		panic(err)
 	}
+	return 0
+}
+
+var commands = map[string]func([]string) int{
+	"serve":    servecmd,
+	"generate": generatecmd,
+	"validate": validatecmd,
+	"diff":     diffcmd,
+}
+
+func main() {
+	cmd, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
+	}
+	run, ok := commands[cmd]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q, available: serve, generate, validate, diff\n", cmd)
+		os.Exit(2)
+	}
+	os.Exit(run(args))
 }
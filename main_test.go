@@ -1,11 +1,1480 @@
 package main
 
 import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
+func TestDescriptionLink(t *testing.T) {
+	e := event{Start: "20130531-1000", End: "20130531-1100", Title: "Hello World", Desc: "About the world.", Link: "https://example.org/hello", Place: "A"}
+	if d := e.Description(); !strings.HasPrefix(d, "About the world.\n\nhttps://example.org/hello") {
+		t.Errorf("link replaced the description: %q", d)
+	}
+}
+
+// protofields decodes a protobuf message for inspection, varints as decimal
+// strings.
+func protofields(t *testing.T, buf []byte) map[int][]string {
+	t.Helper()
+	ret := map[int][]string{}
+	for len(buf) > 0 {
+		key, n := binary.Uvarint(buf)
+		buf = buf[n:]
+		switch key & 7 {
+		case 0:
+			v, n := binary.Uvarint(buf)
+			ret[int(key>>3)] = append(ret[int(key>>3)], strconv.FormatUint(v, 10))
+			buf = buf[n:]
+		case 2:
+			l, n := binary.Uvarint(buf)
+			ret[int(key>>3)] = append(ret[int(key>>3)], string(buf[n:n+int(l)]))
+			buf = buf[n+int(l):]
+		default:
+			t.Fatalf("unexpected wire type in %x", buf)
+		}
+	}
+	return ret
+}
+
+func grpcrequest(t *testing.T, ctx context.Context, srv *httptest.Server, method string, msg []byte) *http.Response {
+	t.Helper()
+	frame := binary.BigEndian.AppendUint32([]byte{0}, uint32(len(msg)))
+	r, _ := http.NewRequestWithContext(ctx, "POST", srv.URL+grpcprefix+method, bytes.NewReader(append(frame, msg...)))
+	r.Header.Set("Content-Type", "application/grpc")
+	r.Header.Set("TE", "trailers")
+	resp, err := srv.Client().Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.ProtoMajor != 2 || resp.Header.Get("Content-Type") != "application/grpc+proto" {
+		t.Fatalf("%s: %s %q", method, resp.Proto, resp.Header.Get("Content-Type"))
+	}
+	return resp
+}
+
+func readgrpcframe(t *testing.T, r io.Reader) map[int][]string {
+	t.Helper()
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, binary.BigEndian.Uint32(header[1:]))
+	if _, err := io.ReadFull(r, buf); err != nil {
+		t.Fatal(err)
+	}
+	return protofields(t, buf)
+}
+
+func TestGRPC(t *testing.T) {
+	defer published.Store(current())
+	defer func(f func() time.Time) { now = f }(now)
+	now = func() time.Time { return time.Date(2013, 5, 31, 10, 30, 0, 0, loc) }
+	c := calendar{
+		{Start: "20130531-1000", End: "20130531-1100", Title: "running", Speaker: "alice", Place: "Großer Saal"},
+		{Start: "20130531-1200", End: "20130531-1300", Title: "next", Place: "Großer Saal"},
+		{Start: "20130531-1200", End: "20130531-1300", Title: "elsewhere", Place: "B"},
+	}
+	publish(append(calendar{}, c...))
+	mux := http.NewServeMux()
+	mux.HandleFunc(grpcprefix, handlegrpc)
+	srv := httptest.NewUnstartedServer(mux)
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+	ctx := context.Background()
+
+	resp := grpcrequest(t, ctx, srv, "ListEvents", protostring(nil, 1, "grosser-saal"))
+	list := readgrpcframe(t, resp.Body)
+	io.Copy(io.Discard, resp.Body)
+	if resp.Trailer.Get("Grpc-Status") != "0" || list[1][0] != current().version || len(list[2]) != 2 {
+		t.Fatalf("ListEvents: %s %v", resp.Trailer.Get("Grpc-Status"), list)
+	}
+	first := protofields(t, []byte(list[2][0]))
+	start := protofields(t, []byte(first[7][0]))
+	if first[2][0] != "running" || first[3][0] != "alice" || first[6][0] != "Großer Saal" || start[1][0] != strconv.FormatInt(c[0].Starttime().Unix(), 10) {
+		t.Errorf("event %v", first)
+	}
+
+	resp = grpcrequest(t, ctx, srv, "GetRoomNowNext", protostring(nil, 1, "Großer Saal"))
+	nn := readgrpcframe(t, resp.Body)
+	io.Copy(io.Discard, resp.Body)
+	if protofields(t, []byte(nn[2][0]))[2][0] != "running" || protofields(t, []byte(nn[3][0]))[2][0] != "next" {
+		t.Errorf("GetRoomNowNext: %v", nn)
+	}
+	resp = grpcrequest(t, ctx, srv, "GetRoomNowNext", protostring(nil, 1, "nowhere"))
+	io.Copy(io.Discard, resp.Body)
+	if resp.Trailer.Get("Grpc-Status") != "5" {
+		t.Errorf("unknown room: status %q", resp.Trailer.Get("Grpc-Status"))
+	}
+
+	before := current().version
+	c[2].Desc = "moved here"
+	publish(append(calendar{}, c...))
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	resp = grpcrequest(t, ctx, srv, "WatchChanges", protostring(nil, 1, before))
+	defer resp.Body.Close()
+	catchup := readgrpcframe(t, resp.Body)
+	if catchup[1][0] != "3" || catchup[4][0] != "Desc" || catchup[5][0] != current().version || protofields(t, []byte(catchup[3][0]))[9][0] != "moved here" {
+		t.Errorf("catching up: %v", catchup)
+	}
+	c = append(c, event{Start: "20130531-1400", End: "20130531-1500", Title: "added", Place: "B"})
+	publish(append(calendar{}, c...))
+	live := readgrpcframe(t, resp.Body)
+	if live[1][0] != "1" || protofields(t, []byte(live[3][0]))[2][0] != "added" {
+		t.Errorf("live change: %v", live)
+	}
+}
+
+func TestGenerateLeavesOutPrivateRooms(t *testing.T) {
+	defer setconf(*conf())
+	dir := t.TempDir()
+	source := filepath.Join(dir, "schedule.json")
+	os.WriteFile(source, []byte(`[
+		{"Start": "20130531-1000", "End": "20130531-1100", "Title": "public talk", "Place": "A"},
+		{"Start": "20130531-1000", "End": "20130531-1100", "Title": "orga meeting", "Place": "Orga"}
+	]`), 0644)
+	conf().Private = map[location]string{"Orga": "secret"}
+	out := filepath.Join(dir, "out")
+	if code := generatecmd([]string{"-source", source, "-out", out}); code != 0 {
+		t.Fatalf("generate exited with %d", code)
+	}
+	if _, err := os.Stat(filepath.Join(out, icsfilename("Orga"))); !os.IsNotExist(err) {
+		t.Errorf("private room written: %v", err)
+	}
+	all, err := os.ReadFile(filepath.Join(out, icsfilename("Alle")))
+	if err != nil || !strings.Contains(string(all), "public talk") || strings.Contains(string(all), "orga meeting") {
+		t.Errorf("combined calendar: %v\n%s", err, all)
+	}
+}
+
 func TestLongLines(t *testing.T) {
 	w := NewBreakLongLineWriter(os.Stdout, 10)
 	w.Write([]byte("0123456789012345678901234567890123456789\n012345678901234567890123456789\n0123456789\n0123"))
 }
+
+func TestBreaks(t *testing.T) {
+	defer setconf(*conf())
+	conf().BreakMinGap, conf().BreakMaxGap, conf().LunchMinGap = 10, 180, 45
+
+	events := addbreaks(calendar{
+		{Start: "20130531-1000", End: "20130531-1100", Place: "A"},
+		{Start: "20130531-1105", End: "20130531-1200", Place: "A"},
+		{Start: "20130531-1300", End: "20130531-1400", Place: "A"},
+		{Start: "20130531-1420", End: "20130531-1500", Place: "A"},
+		{Start: "20130531-2300", End: "20130531-2359", Place: "A"},
+		{Start: "20130531-1000", End: "20130531-1100", Place: ""},
+	})
+
+	var titles []string
+	for _, e := range events[6:] {
+		titles = append(titles, e.Start+" "+e.Title)
+	}
+	if len(titles) != 2 || titles[0] != "20130531-1200 Lunch" || titles[1] != "20130531-1400 Break" {
+		t.Errorf("unexpected breaks %q", titles)
+	}
+
+	conf().Language = "de"
+	events = addbreaks(calendar{
+		{Start: "20130531-1000", End: "20130531-1100", Place: "A"},
+		{Start: "20130531-1120", End: "20130531-1200", Place: "A"},
+	})
+	if len(events) != 3 || events[2].Title != "Pause" {
+		t.Errorf("German breaks %v", events[2:])
+	}
+}
+
+func TestConflicts(t *testing.T) {
+	c := conflicts(calendar{
+		{Start: "20130531-1000", End: "20130531-1100", Place: "A", Title: "a", Speaker: "x, y"},
+		{Start: "20130531-1030", End: "20130531-1130", Place: "A", Title: "b"},
+		{Start: "20130531-1045", End: "20130531-1115", Place: "B", Title: "c", Speaker: "y"},
+		{Start: "20130531-1100", End: "20130531-1200", Place: "A", Title: "d", Speaker: "x"},
+	})
+	var reasons []string
+	for _, cf := range c {
+		reasons = append(reasons, cf.Reason+" "+cf.A.Title+cf.B.Title)
+	}
+	if len(c) != 3 || reasons[0] != "room A ab" || reasons[1] != "speaker y ac" || reasons[2] != "room A bd" {
+		t.Errorf("unexpected conflicts %q", reasons)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	idx := newsearchindex(calendar{
+		{Title: "DNS for fun and profit", Speaker: "alice"},
+		{Title: "Kubernetes", Long_desc: "containers everywhere"},
+		{Title: "Lightning talks", Speaker: "bob"},
+	})
+	for q, want := range map[string]int{
+		"dns":          1,
+		"kube":         1,
+		"contianers":   1,
+		"lightning bo": 1,
+		"alice bob":    0,
+		"":             0,
+	} {
+		if got := len(idx.Search(q)); got != want {
+			t.Errorf("Search(%q) = %d results, want %d", q, got, want)
+		}
+	}
+}
+
+func TestQR(t *testing.T) {
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	want := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+	if got := rsremainder(data, rsdivisor(10)); !bytes.Equal(got, want) {
+		t.Errorf("rsremainder = %v, want %v", got, want)
+	}
+	if got := qrformatbits(1); got != 0x5125 {
+		t.Errorf("qrformatbits(1) = %015b", got)
+	}
+	if got := qrversionbits(7); got != 0x07c94 {
+		t.Errorf("qrversionbits(7) = %018b", got)
+	}
+	q, err := newqrcode("webcal://localhost:8000/Medientheater")
+	if err != nil || q.size != 29 {
+		t.Errorf("newqrcode: %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	c := calendar{
+		{Start: "20130531-1000", End: "20130531-1100", Place: "A", Title: "a", Long_desc: strings.Repeat("long description ", 20)},
+		{Start: "20130531-1200", End: "20130531-1100", Place: "A", Title: "b"},
+	}
+	problems := validateical(c.ICal())
+	if len(problems) != 1 || !strings.Contains(problems[0].msg, "DTEND before DTSTART") {
+		t.Errorf("unexpected problems %v", problems)
+	}
+
+	broken := "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nBEGIN:VEVENT\r\nDTSTART:2013\r\nEND:VEVENT\r\n"
+	if problems := validateical([]byte(broken)); len(problems) != 4 {
+		t.Errorf("unexpected problems %v", problems)
+	}
+}
+
+func TestRefreshInterval(t *testing.T) {
+	defer setconf(*conf())
+	conf().Jitter = 0
+	for now, want := range map[time.Time]time.Duration{
+		gpnstart.Add(-48 * time.Hour): time.Hour,
+		gpnstart.Add(-2 * time.Hour):  5 * time.Minute,
+		gpnstart.Add(time.Hour):       2 * time.Minute,
+		gpnstop.Add(time.Hour):        5 * time.Minute,
+		gpnstop.Add(48 * time.Hour):   time.Hour,
+	} {
+		if got := refreshinterval(now); got != want {
+			t.Errorf("refreshinterval(%v) = %v, want %v", now, got, want)
+		}
+	}
+
+	conf().Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if got := refreshinterval(gpnstart); got < time.Minute || got > 3*time.Minute {
+			t.Fatalf("jittered interval %v out of range", got)
+		}
+	}
+}
+
+func TestICalDuration(t *testing.T) {
+	for d, want := range map[time.Duration]string{
+		0:                             "PT0S",
+		45 * time.Minute:              "PT45M",
+		90 * time.Minute:              "PT1H30M",
+		24 * time.Hour:                "P1D",
+		25*time.Hour + 30*time.Second: "P1DT1H30S",
+		-time.Hour:                    "-PT1H",
+	} {
+		got := icalduration(d)
+		if got != want {
+			t.Errorf("icalduration(%v) = %q, want %q", d, got, want)
+		}
+		if !icaldurationpattern.MatchString(got) {
+			t.Errorf("icalduration(%v) = %q is not a valid DURATION", d, got)
+		}
+	}
+}
+
+func TestRelated(t *testing.T) {
+	c := calendar{
+		{Start: "20130531-1000", Title: "Rust Workshop (Part 1/2)", Speaker: "x"},
+		{Start: "20130531-1400", Title: "Rust Workshop (Part 2/2)", Speaker: "x"},
+		{Start: "20130531-1400", Title: "Rust Workshop Teil 3", Speaker: "y"},
+		{Start: "20130601-1000", Title: "Opening", Series: "ceremony"},
+		{Start: "20130602-1500", Title: "Closing", Series: "ceremony"},
+	}
+	related := c.Related()
+	if len(related) != 4 || related[c[0].UID()][0] != c[1].UID() || related[c[4].UID()][0] != c[3].UID() {
+		t.Errorf("unexpected relations %v", related)
+	}
+	if !strings.Contains(strings.ReplaceAll(string(c.ICal()), "\r\n ", ""), "RELATED-TO;RELTYPE=SIBLING:"+c[1].UID()) {
+		t.Error("missing RELATED-TO property")
+	}
+}
+
+func TestRecurring(t *testing.T) {
+	c, rrules := calendar{
+		{Start: "20130531-0800", End: "20130531-0900", Title: "Yoga", Place: "Wiese"},
+		{Start: "20130531-1000", End: "20130531-1100", Title: "Talk", Place: "A"},
+		{Start: "20130601-0800", End: "20130601-0900", Title: "Yoga", Place: "Wiese"},
+		{Start: "20130602-0800", End: "20130602-0900", Title: "Yoga", Place: "Wiese"},
+		{Start: "20130531-1200", End: "20130531-1300", Title: "Infodesk", Place: "Foyer"},
+		{Start: "20130602-1200", End: "20130602-1300", Title: "Infodesk", Place: "Foyer"},
+		{Start: "20130603-1200", End: "20130603-1300", Title: "Infodesk", Place: "Foyer"},
+	}.Recurring()
+	if len(c) != 5 || len(rrules) != 1 || rrules[c[0].UID()] != "FREQ=DAILY;COUNT=3" {
+		t.Errorf("unexpected recurrence %d %v", len(c), rrules)
+	}
+}
+
+func TestFilters(t *testing.T) {
+	defer setconf(*conf())
+	events := calendar{
+		{Title: "Opening", Place: "A", Type: "talk"},
+		{Title: "TBA", Place: "A", Type: "talk"},
+		{Title: "Lightning Talks", Place: "B", Type: "lightning"},
+		{Title: "Orga meeting", Place: "Orga", Type: "talk"},
+	}
+
+	conf().Deny = eventfilter{Rooms: []location{"Orga"}, Titles: []string{`^TBA$`}}
+	if got := filterevents(events); len(got) != 2 || got[0].Title != "Opening" || got[1].Title != "Lightning Talks" {
+		t.Errorf("deny list: got %v", got)
+	}
+
+	conf().Allow = eventfilter{Types: []string{"lightning"}}
+	if got := filterevents(events); len(got) != 1 || got[0].Title != "Lightning Talks" {
+		t.Errorf("allow list: got %v", got)
+	}
+}
+
+func TestMergeSources(t *testing.T) {
+	defer setconf(*conf())
+	conf().Merge = map[string]string{"Desc": "wiki"}
+
+	got := mergesources([]sourceevents{
+		{"fahrplan", calendar{
+			{Title: "Lightning Talks", Start: "20130531-1000", Place: "A", Desc: "short"},
+			{Title: "Lightning Talks", Start: "20130601-1000", Place: "A"},
+		}},
+		{"wiki", calendar{
+			{Title: "lightning  talks", Start: "20130531-1100", Desc: "long", Link: "https://example.org/lt"},
+			{Title: "Workshop", Start: "20130531-1400", Place: "B"},
+		}},
+	})
+	if len(got) != 3 {
+		t.Fatalf("got %d events, want 3", len(got))
+	}
+	if e := got[0]; e.Start != "20130531-1000" || e.Place != "A" || e.Desc != "long" || e.Link != "https://example.org/lt" {
+		t.Errorf("first lightning talks merged to %+v", e)
+	}
+	if e := got[1]; e.Start != "20130601-1000" || e.Desc != "" {
+		t.Errorf("second lightning talks merged to %+v", e)
+	}
+	if got[2].Title != "Workshop" {
+		t.Errorf("workshop missing: %+v", got[2])
+	}
+}
+
+func TestRoomRedirects(t *testing.T) {
+	defer published.Store(current())
+	published.Store(&snapshot{icals: map[location][]byte{"Großer Saal": []byte("BEGIN:VCALENDAR")}})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", handle)
+	mux.HandleFunc("/rooms/", handlerooms)
+	for path, want := range map[string]string{
+		"/Gro%C3%9Fer%20Saal":          "/rooms/grosser-saal.ics",
+		"/Gro%C3%9Fer%20Saal.ics/?x=1": "/rooms/grosser-saal.ics?x=1",
+		"/rooms/grosser-saal":          "/rooms/grosser-saal.ics",
+		"/rooms/grosser-saal.ics/":     "/rooms/grosser-saal.ics",
+		"/rooms//grosser-saal.ics":     "/rooms/grosser-saal.ics",
+		"/rooms/x/../grosser-saal.ics": "/rooms/grosser-saal.ics",
+		"/rooms/Gro%C3%9Fer-Saal.ics":  "/rooms/grosser-saal.ics",
+		"/rooms/grosser-saal.ics":      "",
+		"/nowhere":                     "404",
+	} {
+		rec := httptest.NewRecorder()
+		withcleanpath(mux).ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		switch {
+		case want == "" && rec.Code != http.StatusOK,
+			want == "404" && rec.Code != http.StatusNotFound,
+			want != "" && want != "404" && (rec.Code != http.StatusMovedPermanently || rec.Header().Get("Location") != want):
+			t.Errorf("%s: %d %q, want %q", path, rec.Code, rec.Header().Get("Location"), want)
+		}
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	h := withrequestid(http.NotFoundHandler())
+
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-Request-ID", "abc-123")
+	h.ServeHTTP(rec, r)
+	if id := rec.Header().Get("X-Request-ID"); id != "abc-123" {
+		t.Errorf("propagated id %q", id)
+	}
+	if !strings.Contains(rec.Body.String(), "request id: abc-123") {
+		t.Errorf("error body %q", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	r.Header.Set("X-Request-ID", "bad\nid")
+	h.ServeHTTP(rec, r)
+	if id := rec.Header().Get("X-Request-ID"); len(id) != 16 {
+		t.Errorf("generated id %q", id)
+	}
+}
+
+func TestAccessLogRedacts(t *testing.T) {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+	h := withrequestid(http.NotFoundHandler())
+	for _, c := range []struct{ uri, want, secret string }{
+		{"/angels/s3cret.ics", " /angels/… ", "s3cret"},
+		{"/feeds/s3cret.ics?tz=UTC", " /feeds/… ", "s3cret"},
+		{"/rooms/Backstage.ics?tz=UTC&token=s3cret", " /rooms/Backstage.ics?token=redacted&tz=UTC ", "s3cret"},
+	} {
+		logged.Reset()
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", c.uri, nil))
+		if line := logged.String(); !strings.Contains(line, c.want) || strings.Contains(line, c.secret) {
+			t.Errorf("%s logged as %q", c.uri, line)
+		}
+	}
+}
+
+func TestRecover(t *testing.T) {
+	h := withrequestid(withrecover(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("status %d", rec.Code)
+	}
+}
+
+func TestListenerHandlers(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	for _, c := range []struct {
+		l      listener
+		path   string
+		status int
+	}{
+		{listener{}, "/admin/", 200},
+		{listener{Serve: "public"}, "/", 200},
+		{listener{Serve: "public"}, "/admin/", 404},
+		{listener{Serve: "public"}, "/metrics", 404},
+		{listener{Serve: "admin"}, "/admin/", 200},
+		{listener{Serve: "admin"}, "/", 404},
+		{listener{Serve: "redirect", Redirect: "https://example.org/"}, "/rooms/a.ics?tz=UTC", 301},
+	} {
+		rec := httptest.NewRecorder()
+		c.l.handler(ok).ServeHTTP(rec, httptest.NewRequest("GET", c.path, nil))
+		if rec.Code != c.status {
+			t.Errorf("%q on %s: status %d, want %d", c.l.Serve, c.path, rec.Code, c.status)
+		}
+		if c.status == 301 && rec.Header().Get("Location") != "https://example.org/rooms/a.ics?tz=UTC" {
+			t.Errorf("redirect to %q", rec.Header().Get("Location"))
+		}
+	}
+}
+
+func TestAdminClientCert(t *testing.T) {
+	defer setconf(*conf())
+	conf().Admin.ClientCA = "orga-ca.pem"
+	conf().Admin.Password = ""
+	h := adminauth(func(w http.ResponseWriter, r *http.Request) {})
+
+	rec := httptest.NewRecorder()
+	h(rec, httptest.NewRequest("GET", "/admin/", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("without certificate: status %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "https://example.org/admin/", nil)
+	r.TLS.VerifiedChains = [][]*x509.Certificate{{{}}}
+	h(rec, r)
+	if rec.Code != http.StatusOK {
+		t.Errorf("with certificate: status %d", rec.Code)
+	}
+}
+
+// TestConcurrentPublish is meant to be run with -race.
+func TestConcurrentPublish(t *testing.T) {
+	defer published.Store(current())
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", handle)
+	mux.HandleFunc("/rooms/", handlerooms)
+	mux.HandleFunc("/events/", handleevent)
+	mux.HandleFunc("/search", handlesearch)
+	mux.HandleFunc("/now", handlenow)
+	mux.HandleFunc("/schedule.json", handleschedulejson)
+	mux.HandleFunc("/schedule.pdf", handletimetable)
+	mux.HandleFunc("/export.xlsx", handlexlsx)
+	mux.HandleFunc("/api/events", apievents)
+	h := withrequestid(withrecover(withversion(mux)))
+
+	syncs := func(n int) calendar {
+		c := calendar{}
+		for i := 0; i < 20; i++ {
+			c = append(c, event{
+				Title: fmt.Sprintf("Talk %d", i),
+				Start: fmt.Sprintf("201305%02d-%02d00", 30+i%2, 10+i%8),
+				End:   fmt.Sprintf("201305%02d-%02d45", 30+i%2, 10+i%8),
+				Place: location(fmt.Sprintf("Saal %d", (i+n)%3)),
+			})
+		}
+		return c
+	}
+	paths := []string{"/", "/rooms/saal-0.ics", "/rooms/saal-1.ics?duration=true", "/Saal 2", "/search?q=talk",
+		"/now", "/schedule.json", "/schedule.pdf", "/export.xlsx", "/api/events?limit=5"}
+
+	var wg sync.WaitGroup
+	for n := 0; n < 2; n++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				if err := publish(syncs(n + i)); err != nil {
+					t.Error(err)
+				}
+			}
+		}(n)
+	}
+	var clients sync.WaitGroup
+	for _, path := range paths {
+		clients.Add(1)
+		go func(path string) {
+			defer clients.Done()
+			for i := 0; i < 20; i++ {
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, httptest.NewRequest("GET", strings.ReplaceAll(path, " ", "%20"), nil))
+				if rec.Code >= 500 {
+					t.Errorf("%s: %d", path, rec.Code)
+				}
+				if cl := rec.Header().Get("Content-Length"); cl != "" && cl != strconv.Itoa(rec.Body.Len()) {
+					t.Errorf("%s: Content-Length %s for %d bytes", path, cl, rec.Body.Len())
+				}
+			}
+		}(path)
+	}
+	clients.Wait()
+	wg.Wait()
+}
+
+func benchmarkcalendar() calendar {
+	c := calendar{}
+	for i := 0; i < 500; i++ {
+		c = append(c, event{
+			Title:     fmt.Sprintf("Über die Zukunft %d, Teil %d", i/3, i%3+1),
+			Speaker:   "Jane Doe",
+			Start:     fmt.Sprintf("201305%02d-%02d%02d", 30+i%3, 10+i%12, i%60),
+			End:       fmt.Sprintf("201305%02d-%02d%02d", 30+i%3, 11+i%12, i%60),
+			Place:     location(fmt.Sprintf("Saal %d", i%5)),
+			Type:      "talk",
+			Language:  "de",
+			Desc:      strings.Repeat("Ein Vortrag über Dinge; mit Kommas, Zeilen\nund Umlauten äöü. ", 8),
+			Link:      "https://entropia.de/GPN13",
+			Long_desc: "",
+		})
+	}
+	return c
+}
+
+func BenchmarkICal(b *testing.B) {
+	c := benchmarkcalendar()
+	b.ReportAllocs()
+	for b.Loop() {
+		c.ICal()
+	}
+}
+
+func BenchmarkRenderCalendars(b *testing.B) {
+	c := benchmarkcalendar()
+	b.ReportAllocs()
+	for b.Loop() {
+		rendercalendars(c, defaulticaloptions())
+	}
+}
+
+func TestDeterministicICal(t *testing.T) {
+	c := benchmarkcalendar()
+	opt := defaulticaloptions()
+	opt.stamp = "20130531T100000Z"
+	want := c.ICalWith(opt)
+	shuffled := append(calendar(nil), c...)
+	rand.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+	if !bytes.Equal(shuffled.ICalWith(opt), want) {
+		t.Error("event order changes the output")
+	}
+
+	defer published.Store(current())
+	publish(c)
+	snap := *current()
+	snap.stamp = "20130531T100000Z"
+	published.Store(&snap)
+	publish(shuffled)
+	if current().stamp != snap.stamp {
+		t.Error("republishing the same schedule changes DTSTAMP")
+	}
+}
+
+func TestCheckConfig(t *testing.T) {
+	buf := []byte("{\n\t\"Listen\": \":8000\",\n\t\"Intervall\": 5,\n\t\"Sources\": [\n\t\t{\"Name\": \"a\", \"URL\": \"https://example.org/a.json\"},\n\t\t{\"URL\": \"https://example.org/b.json\"}\n\t],\n\t\"Times\": \"local\"\n}\n")
+	_, err := decodeconfig("test.json", buf, defaultconfig())
+	want := "test.json:3: Intervall: unknown setting\n" +
+		"test.json:6: Sources[1].Name: sources need a name\n" +
+		"test.json:8: Times: must be one of utc, floating or tzid"
+	if err == nil || err.Error() != want {
+		t.Errorf("got\n%v\nwant\n%s", err, want)
+	}
+}
+
+func TestReloadConfig(t *testing.T) {
+	defer setconf(*conf())
+	defer func() { sourcestates = nil }()
+	path := filepath.Join(t.TempDir(), "config.json")
+	os.WriteFile(path, []byte(`{"Sources": [{"Name": "a", "URL": "https://example.org/a.json"}]}`), 0o644)
+	if err := readconfig(path); err != nil {
+		t.Fatal(err)
+	}
+	initsources()
+	togglesource("a")
+	sourcestates[0].upstream = []byte(`[]`)
+
+	os.WriteFile(path, []byte(`{"Sources": [{"Name": "a", "URL": "https://example.org/a.json"}, {"URL": "https://example.org/b.json"}]}`), 0o644)
+	if err := reloadconfig(path); err == nil {
+		t.Error("broken config was accepted")
+	}
+	if len(conf().Sources) != 1 {
+		t.Errorf("broken config replaced the running one: %v", conf().Sources)
+	}
+
+	os.WriteFile(path, []byte(`{"Sources": [{"Name": "a", "URL": "https://example.org/a.json"}, {"Name": "b", "URL": "https://example.org/b.json"}]}`), 0o644)
+	if err := reloadconfig(path); err != nil {
+		t.Fatal(err)
+	}
+	s := currentsyncstatus().Sources
+	if len(s) != 2 || !s[0].Disabled || s[1].Disabled || string(s[0].upstream) != `[]` {
+		t.Errorf("sources after reload: %+v", s)
+	}
+
+	os.WriteFile(path, []byte(`{"Sources": [{"Name": "a", "URL": "https://example.org/c.json"}]}`), 0o644)
+	if err := reloadconfig(path); err != nil {
+		t.Fatal(err)
+	}
+	if s := currentsyncstatus().Sources; len(s) != 1 || s[0].Disabled || s[0].upstream != nil {
+		t.Errorf("source with a new URL: %+v", s)
+	}
+
+	t.Cleanup(func() { loadtemplates() })
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "now.html"), []byte(`custom now`), 0o644)
+	os.WriteFile(path, []byte(`{"Templates": `+strconv.Quote(dir)+`, "Sources": [{"Name": "a", "URL": "https://example.org/c.json"}]}`), 0o644)
+	if err := reloadconfig(path); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := executetemplate(&buf, "en", loc, "now.html", nil); err != nil || buf.String() != "custom now" {
+		t.Errorf("templates after reload: %q, %v", buf.String(), err)
+	}
+}
+
+func TestParseScheduleAnomalies(t *testing.T) {
+	events, found, err := parseschedule([]byte(`[{"Start": "20130602-1200", "Title": "ok", "Place": "a"}, {"Start": "soon", "Place": "a", "Speakers": "x"}, 42]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Errorf("%d events, want 2", len(events))
+	}
+	var kinds []string
+	for _, a := range found {
+		kinds = append(kinds, fmt.Sprintf("%d %s", a.Index, a.Kind))
+	}
+	if got, want := strings.Join(kinds, ", "), "1 field, 1 title, 1 timestamp, 2 dropped"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestFixEnd(t *testing.T) {
+	events, found, err := parseschedule([]byte(`[
+		{"Start": "20130531-2300", "End": "20130531-0100", "Title": "night", "Place": "a"},
+		{"Start": "20130531-1400", "End": "20130531-1000", "Title": "swapped", "Place": "a"}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if events[0].End != "20130601-0100" || events[1].End != "20130531-1500" {
+		t.Errorf("corrected ends %q, %q", events[0].End, events[1].End)
+	}
+	if len(found) != 2 || found[0].Kind != "timestamp" || found[1].Kind != "timestamp" {
+		t.Errorf("anomalies %+v", found)
+	}
+}
+
+func TestDurationLimits(t *testing.T) {
+	defer setconf(*conf())
+	buf := []byte(`[
+		{"Start": "20130530-1000", "End": "20130602-1800", "Title": "everything", "Place": "a"},
+		{"Start": "20130531-1000", "End": "20130531-1000", "Title": "nothing", "Place": "a"},
+		{"Start": "20130531-1000", "End": "20130531-1100", "Title": "talk", "Place": "a"}
+	]`)
+	_, found, _ := parseschedule(buf)
+	if len(found) != 2 || found[0].Index != 0 || found[1].Index != 1 || found[0].Kind != "duration" {
+		t.Errorf("anomalies %+v", found)
+	}
+
+	conf().ClampDurations = true
+	events, _, _ := parseschedule(buf)
+	if events[0].End != "20130530-2200" || events[1].End != "20130531-1001" || events[2].End != "20130531-1100" {
+		t.Errorf("clamped ends %q, %q, %q", events[0].End, events[1].End, events[2].End)
+	}
+}
+
+func TestConferenceDay(t *testing.T) {
+	defer setconf(*conf())
+	conf().DayChange = 4
+	c := calendar{
+		{Start: "20130531-2300", End: "20130601-0100", Place: "A"},
+		{Start: "20130601-0200", End: "20130601-0300", Place: "A"},
+		{Start: "20130601-1000", End: "20130601-1100", Place: "A"},
+	}
+	day, _ := parseday("2013-05-31")
+	if n := len(c.Day(day)); n != 2 {
+		t.Errorf("%d events on the 31st, want 2", n)
+	}
+	if days := timetabledays(c); len(days) != 2 {
+		t.Errorf("%d timetable days, want 2", len(days))
+	}
+}
+
+func TestDayPages(t *testing.T) {
+	defer published.Store(current())
+	publish(calendar{
+		{Start: "20130531-1000", End: "20130531-1100", Title: "Friday talk", Place: "A"},
+		{Start: "20130601-1000", End: "20130601-1100", Title: "Saturday talk", Place: "B"},
+	})
+
+	rec := httptest.NewRecorder()
+	handledays(rec, httptest.NewRequest("GET", "/days/", nil))
+	if loc := rec.Header().Get("Location"); loc != "/days/2013-05-31" {
+		t.Errorf("redirect to %q", loc)
+	}
+
+	rec = httptest.NewRecorder()
+	handledays(rec, httptest.NewRequest("GET", "/days/2013-06-01?lang=en", nil))
+	body := rec.Body.String()
+	if !strings.Contains(body, "Saturday talk") || strings.Contains(body, "Friday talk") || !strings.Contains(body, `href="/days/2013-05-31">Friday`) {
+		t.Errorf("day page:\n%s", body)
+	}
+
+	rec = httptest.NewRecorder()
+	handledoorsign(rec, httptest.NewRequest("GET", "/doorsign/A?day=2013-06-01", nil))
+	if strings.Contains(rec.Body.String(), "Friday talk") {
+		t.Error("door sign shows other days")
+	}
+}
+
+func TestExclude(t *testing.T) {
+	c := calendar{
+		{Title: "talk", Place: "Großer Saal", Type: "talk"},
+		{Title: "music", Place: "Lounge", Type: "concert"},
+		{Title: "chill", Place: "Chillout"},
+	}
+	got := c.Exclude([]string{"lounge", " Chillout", ""})
+	if len(got) != 1 || got[0].Title != "talk" {
+		t.Errorf("excluding rooms: %v", got)
+	}
+	if got := c.Exclude([]string{"concert", "grosser-saal"}); len(got) != 1 || got[0].Title != "chill" {
+		t.Errorf("excluding types and slugs: %v", got)
+	}
+}
+
+func TestCalendarFeeds(t *testing.T) {
+	defer published.Store(current())
+	publish(calendar{
+		{Start: "20130531-1000", End: "20130531-1100", Title: "a", Speaker: "Jane Doe", Type: "talk", Place: "A"},
+		{Start: "20130601-1000", End: "20130601-1100", Title: "b", Type: "talk", Place: "B"},
+	})
+	rec := httptest.NewRecorder()
+	handlecalendars(rec, httptest.NewRequest("GET", "http://example.org/api/calendars?lang=en", nil))
+	var feeds []calendarfeed
+	if err := json.Unmarshal(rec.Body.Bytes(), &feeds); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, f := range feeds {
+		got = append(got, fmt.Sprintf("%s %s %s %d", f.Kind, f.Name, f.URL, f.Events))
+	}
+	want := []string{
+		"room A http://example.org/rooms/a.ics 1",
+		"room Alle http://example.org/rooms/alle.ics 2",
+		"room B http://example.org/rooms/b.ics 1",
+		"track talk http://example.org/tracks/talk.ics 2",
+		"speaker Jane Doe http://example.org/speakers/Jane%20Doe.ics 1",
+		"day Friday, 31.05. http://example.org/all.ics?day=2013-05-31 1",
+		"day Saturday, 01.06. http://example.org/all.ics?day=2013-06-01 1",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestCalDAVDiscovery(t *testing.T) {
+	rec := httptest.NewRecorder()
+	handlewellknowncaldav(rec, httptest.NewRequest("PROPFIND", "/.well-known/caldav", nil))
+	if rec.Code != http.StatusMovedPermanently || rec.Header().Get("Location") != "/caldav/" {
+		t.Errorf("well-known: %d to %q", rec.Code, rec.Header().Get("Location"))
+	}
+
+	rec = httptest.NewRecorder()
+	handle(rec, httptest.NewRequest("PROPFIND", "/", nil))
+	if rec.Code != 207 || !strings.Contains(rec.Body.String(), "<c:calendar-home-set><d:href>/caldav/</d:href>") {
+		t.Errorf("root: %d\n%s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestDAV(t *testing.T) {
+	defer published.Store(current())
+	publish(calendar{{Start: "20130531-1000", End: "20130531-1100", Title: "a", Place: "Medientheater"}})
+
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest("PROPFIND", "/dav/", nil)
+	r.Header.Set("Depth", "1")
+	handledav(rec, r)
+	if rec.Code != 207 || !strings.Contains(rec.Body.String(), "<d:href>/dav/Medientheater.ics</d:href>") {
+		t.Errorf("PROPFIND: %d\n%s", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	handledav(rec, httptest.NewRequest("GET", "/dav/Medientheater.ics", nil))
+	if !bytes.Equal(rec.Body.Bytes(), current().icals["Medientheater"]) {
+		t.Errorf("GET: %d\n%s", rec.Code, rec.Body.String())
+	}
+
+	etag := rec.Header().Get("ETag")
+	if etag != davetag(current().icals["Medientheater"]) {
+		t.Errorf("GET: ETag %s", etag)
+	}
+	publish(calendar{
+		{Start: "20130531-1000", End: "20130531-1100", Title: "a", Place: "Medientheater"},
+		{Start: "20130531-1000", End: "20130531-1100", Title: "b", Place: "Vortragsraum"},
+	})
+	rec = httptest.NewRecorder()
+	handledav(rec, httptest.NewRequest("GET", "/dav/Medientheater.ics", nil))
+	if got := rec.Header().Get("ETag"); got != etag {
+		t.Errorf("unchanged file got ETag %s, had %s", got, etag)
+	}
+	rec = httptest.NewRecorder()
+	handledav(rec, httptest.NewRequest("PROPFIND", "/dav/Vortragsraum.ics", nil))
+	if other := davetag(current().icals["Vortragsraum"]); other == etag || !strings.Contains(rec.Body.String(), "<d:getetag>"+xmlescape(other)+"</d:getetag>") {
+		t.Errorf("PROPFIND Vortragsraum.ics:\n%s", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	handledav(rec, httptest.NewRequest("PUT", "/dav/Medientheater.ics", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("PUT: %d", rec.Code)
+	}
+}
+
+func TestBundle(t *testing.T) {
+	defer published.Store(current())
+	publish(calendar{{Start: "20130531-1000", End: "20130531-1100", Title: "a", Place: "Großer Saal"}})
+
+	rec := httptest.NewRecorder()
+	handlebundle(rec, httptest.NewRequest("GET", "/all-calendars.zip", nil))
+	z, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range z.File {
+		names = append(names, f.Name)
+	}
+	if got := strings.Join(names, " "); got != "alle.ics grosser-saal.ics" {
+		t.Errorf("files %s", got)
+	}
+	f, _ := z.File[1].Open()
+	buf, _ := io.ReadAll(f)
+	if !bytes.Equal(buf, current().icals["Großer Saal"]) {
+		t.Error("room calendar differs")
+	}
+}
+
+func TestSignatures(t *testing.T) {
+	defer setconf(*conf())
+	defer published.Store(current())
+	defer func(f func([]byte) ([]byte, error)) { signcalendar = f }(signcalendar)
+	var signed atomic.Int32
+	signcalendar = func(ical []byte) ([]byte, error) {
+		signed.Add(1)
+		return []byte("signature of " + strconv.Itoa(len(ical))), nil
+	}
+	conf().Sign.Key = "orga@example.org"
+	c := calendar{{Start: "20130531-1000", End: "20130531-1100", Title: "a", Place: "A"}}
+	publish(c)
+	publish(c)
+	if signed.Load() != 2 {
+		t.Errorf("signed %d calendars, want 2 once", signed.Load())
+	}
+
+	rec := httptest.NewRecorder()
+	handlerooms(rec, httptest.NewRequest("GET", "/rooms/a.ics.asc", nil))
+	if want := "signature of " + strconv.Itoa(len(current().icals["A"])); rec.Body.String() != want {
+		t.Errorf("got %q, want %q", rec.Body.String(), want)
+	}
+}
+
+func TestChecksums(t *testing.T) {
+	defer published.Store(current())
+	publish(calendar{{Start: "20130531-1000", End: "20130531-1100", Title: "a", Place: "A"}})
+	rec := httptest.NewRecorder()
+	handlechecksums(rec, httptest.NewRequest("GET", "/checksums", nil))
+	lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+	if len(lines) != 3 || !strings.HasSuffix(lines[0], "  rooms/a.ics") || !strings.HasSuffix(lines[2], "  schedule.pdf") {
+		t.Fatalf("checksums:\n%s", rec.Body.String())
+	}
+	if want := fmt.Sprintf("%x", sha256.Sum256(current().icals["A"])); !strings.HasPrefix(lines[0], want) {
+		t.Errorf("%s, want %s", lines[0], want)
+	}
+}
+
+func TestUpstreamMirror(t *testing.T) {
+	defer setconf(*conf())
+	defer func() { sourcestates = nil }()
+	conf().Private = map[location]string{"Orga": "secret"}
+	sourcestates = []*sourcestatus{{
+		source:   source{Name: "fahrplan"},
+		upstream: []byte(`[{"Title": "a", "Place": "A"}, {"Title": "b", "Place": "Orga"}]`),
+		changed:  time.Date(2013, 5, 31, 10, 0, 0, 0, time.UTC),
+	}}
+
+	rec := httptest.NewRecorder()
+	handleupstream(rec, httptest.NewRequest("GET", "/upstream.json", nil))
+	if rec.Body.String() != `[{"Title": "a", "Place": "A"}]` {
+		t.Errorf("mirrored %s", rec.Body.String())
+	}
+	if rec.Header().Get("Last-Modified") != "Fri, 31 May 2013 10:00:00 GMT" {
+		t.Errorf("Last-Modified %q", rec.Header().Get("Last-Modified"))
+	}
+
+	r := httptest.NewRequest("GET", "/upstream.json?source=fahrplan", nil)
+	r.Header.Set("If-None-Match", rec.Header().Get("ETag"))
+	rec = httptest.NewRecorder()
+	handleupstream(rec, r)
+	if rec.Code != http.StatusNotModified {
+		t.Errorf("conditional request: %d", rec.Code)
+	}
+}
+
+func TestImageProxy(t *testing.T) {
+	defer setconf(*conf())
+	defer published.Store(current())
+	png := []byte("\x89PNG\r\n\x1a\n fake image")
+	fetched := 0
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetched++
+		w.Write(png)
+	}))
+	defer origin.Close()
+	conf().Images = t.TempDir()
+	conf().BaseURL = "https://gpn.example.org/"
+	publish(calendar{{Start: "20130531-1000", End: "20130531-1100", Title: "a", Place: "A", Image: origin.URL + "/talk.png"}})
+	e := current().schedule[0]
+
+	if !strings.Contains(strings.ReplaceAll(string(current().icals["A"]), "\r\n ", ""), "IMAGE;VALUE=URI;DISPLAY=BADGE:https://gpn.example.org"+e.ImagePath()) {
+		t.Errorf("no IMAGE in\n%s", current().icals["A"])
+	}
+	for range 2 {
+		rec := httptest.NewRecorder()
+		handleimage(rec, httptest.NewRequest("GET", e.ImagePath(), nil))
+		if !bytes.Equal(rec.Body.Bytes(), png) || rec.Header().Get("Content-Type") != "image/png" {
+			t.Errorf("image: %d %q", rec.Code, rec.Body.String())
+		}
+	}
+	if fetched != 1 {
+		t.Errorf("fetched %d times from the origin", fetched)
+	}
+
+	rec := httptest.NewRecorder()
+	handleimage(rec, httptest.NewRequest("GET", "/img/"+imagekey(origin.URL+"/other.png"), nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("unknown image: %d", rec.Code)
+	}
+}
+
+func TestAvatarsAndIcons(t *testing.T) {
+	defer setconf(*conf())
+	defer published.Store(current())
+	conf().Images = t.TempDir()
+	conf().Tracks = map[string]trackmetadata{"talk": {Icon: "https://example.org/talk.svg"}}
+	publish(calendar{{Start: "20130531-1000", End: "20130531-1100", Title: "a", Speaker: "Jane", Type: "talk", Place: "A", Speaker_image: "https://example.org/jane.jpg"}})
+
+	rec := httptest.NewRecorder()
+	handledays(rec, httptest.NewRequest("GET", "/days/2013-05-31", nil))
+	for _, u := range []string{"https://example.org/talk.svg", "https://example.org/jane.jpg"} {
+		if !strings.Contains(rec.Body.String(), `src="/img/`+imagekey(u)+`"`) {
+			t.Errorf("%s missing on the day page", u)
+		}
+		if _, ok := current().images[imagekey(u)]; !ok {
+			t.Errorf("%s not proxied", u)
+		}
+	}
+	if p := speakers(current().schedule)[0].ImagePath(); p != "/img/"+imagekey("https://example.org/jane.jpg") {
+		t.Errorf("speaker avatar %q", p)
+	}
+}
+
+func TestAngelFeed(t *testing.T) {
+	defer setconf(*conf())
+	defer published.Store(current())
+	t.Cleanup(func() {
+		angelmutex.Lock()
+		defer angelmutex.Unlock()
+		clear(angelcache)
+	})
+	var fetched, rejected atomic.Int32
+	engel := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/shifts-json-export" || r.URL.Query().Get("key") != "good" {
+			rejected.Add(1)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		fetched.Add(1)
+		time.Sleep(10 * time.Millisecond)
+		w.Write([]byte(`[
+			{"title": "", "name": "Einlass", "shifttype_name": "Heaven", "room_name": "Eingang", "start_date": "2013-05-31T14:00:00+02:00", "end_date": "2013-05-31T16:00:00+02:00"},
+			{"name": "Bar", "room_name": "Bar", "starttime": "1370008800", "endtime": 1370016000},
+			{"name": "broken"}
+		]`))
+	}))
+	defer engel.Close()
+	conf().Engelsystem.URL = engel.URL
+	publish(calendar{{Start: "20130531-1000", End: "20130531-1100", Title: "talk", Place: "A"}})
+
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rec := httptest.NewRecorder()
+			handleangels(rec, httptest.NewRequest("GET", "/angels/good.ics", nil))
+			ics := rec.Body.String()
+			if rec.Code != http.StatusOK || strings.Count(ics, "BEGIN:VEVENT") != 3 {
+				t.Errorf("angel feed: %d\n%s", rec.Code, ics)
+				return
+			}
+			for _, want := range []string{`SUMMARY:"talk"`, `SUMMARY:"Angel shift: Heaven: Einlass"`, `SUMMARY:"Angel shift: Bar"`, "DTSTART:20130531T120000Z"} {
+				if !strings.Contains(ics, want) {
+					t.Errorf("no %q in\n%s", want, ics)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if n := fetched.Load(); n != 1 {
+		t.Errorf("fetched %d times from Engelsystem", n)
+	}
+
+	for range 2 {
+		rec := httptest.NewRecorder()
+		handleangels(rec, httptest.NewRequest("GET", "/angels/bad.ics", nil))
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("bad key: %d", rec.Code)
+		}
+	}
+	if n := rejected.Load(); n != 1 {
+		t.Errorf("asked Engelsystem %d times about a rejected key", n)
+	}
+}
+
+func TestFeedbackLinks(t *testing.T) {
+	defer setconf(*conf())
+	defer func(f func() time.Time) { now = f }(now)
+	conf().Feedback = "https://pretalx.example.org/gpn13/talk/{slug}/feedback/"
+	e := event{Start: "20130531-1000", End: "20130531-1100", Title: "Hello World", Place: "A"}
+
+	now = func() time.Time { return time.Date(2013, 5, 31, 10, 30, 0, 0, loc) }
+	before := e.ETag()
+	if strings.Contains(e.DescriptionIn("en"), "feedback") {
+		t.Errorf("feedback link during the talk: %q", e.DescriptionIn("en"))
+	}
+	now = func() time.Time { return time.Date(2013, 5, 31, 11, 30, 0, 0, loc) }
+	if d := e.DescriptionIn("en"); !strings.HasSuffix(d, "Feedback: https://pretalx.example.org/gpn13/talk/hello-world/feedback/") {
+		t.Errorf("no feedback link after the talk: %q", d)
+	}
+	if e.ETag() == before {
+		t.Error("ETag doesn't change when the feedback link appears")
+	}
+	if b := (event{Start: "20130531-1000", End: "20130531-1100", Type: "break"}); b.Feedback() != "" {
+		t.Errorf("feedback for a break: %q", b.Feedback())
+	}
+}
+
+func TestExtensionProperties(t *testing.T) {
+	e := event{Start: "20130531-1000", End: "20130531-1100", Title: "a", Place: "A", Type: "workshop", Confirmed: "yes", Source: "pretalx"}
+	var buf bytes.Buffer
+	e.VEVENT(&buf, icaloptions{})
+	for _, want := range []string{"X-GPN-TRACK:workshop\r\n", "X-GPN-EVENT-ID:" + e.UID() + "\r\n", "X-GPN-CONFIRMED:yes\r\n", "X-GPN-SOURCE:pretalx\r\n"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("no %q in\n%s", want, buf.String())
+		}
+	}
+
+	buf.Reset()
+	(&event{Start: "20130531-1000", Title: "b"}).VEVENT(&buf, icaloptions{})
+	for _, unwanted := range []string{"X-GPN-TRACK", "X-GPN-CONFIRMED", "X-GPN-SOURCE"} {
+		if strings.Contains(buf.String(), unwanted) {
+			t.Errorf("empty %s in\n%s", unwanted, buf.String())
+		}
+	}
+}
+
+func TestSubscriberStats(t *testing.T) {
+	defer setconf(*conf())
+	defer func(f func() time.Time) { now = f }(now)
+	conf().Subscribers = filepath.Join(t.TempDir(), "subscribers.json")
+	conf().SubscriberDays = 2
+	subscribers.loaded, subscribers.day = false, ""
+	defer func() { subscribers.loaded, subscribers.day = false, "" }()
+
+	fetch := func(path, addr, agent string) {
+		r := httptest.NewRequest("GET", path, nil)
+		r.RemoteAddr = addr + ":4711"
+		r.Header.Set("User-Agent", agent)
+		countsubscriber(r, http.StatusOK)
+	}
+	now = func() time.Time { return time.Date(2013, 5, 30, 12, 0, 0, 0, loc) }
+	fetch("/rooms/a.ics", "192.0.2.1", "DAVx5")
+	now = func() time.Time { return time.Date(2013, 5, 31, 12, 0, 0, 0, loc) }
+	for range 3 {
+		fetch("/rooms/a.ics", "192.0.2.1", "DAVx5")
+	}
+	fetch("/rooms/a.ics", "192.0.2.1", "Thunderbird")
+	fetch("/rooms/a.ics", "192.0.2.2", "DAVx5")
+	fetch("/feeds/secret.ics", "192.0.2.2", "DAVx5")
+	fetch("/rooms/", "192.0.2.3", "Firefox")
+	countsubscriber(httptest.NewRequest("GET", "/rooms/b.ics", nil), http.StatusNotFound)
+
+	want := subscribercounts{
+		"2013-05-30": {"/rooms/a.ics": 1},
+		"2013-05-31": {"/rooms/a.ics": 3, "/feeds/": 1},
+	}
+	if got := subscriberstats(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if err := savesubscribers(); err != nil {
+		t.Fatal(err)
+	}
+	subscribers.loaded, subscribers.day = false, ""
+	now = func() time.Time { return time.Date(2013, 6, 1, 12, 0, 0, 0, loc) }
+	fetch("/all.ics", "192.0.2.1", "DAVx5")
+	want = subscribercounts{
+		"2013-05-31": {"/rooms/a.ics": 3, "/feeds/": 1},
+		"2013-06-01": {"/all.ics": 1},
+	}
+	if got := subscriberstats(); !reflect.DeepEqual(got, want) {
+		t.Errorf("after reload and rotation: got %v, want %v", got, want)
+	}
+}
+
+func TestRenderFallback(t *testing.T) {
+	get := func(data any) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		render(rec, httptest.NewRequest("GET", "/now/fallback-test", nil), "now.html", data)
+		return rec
+	}
+	broken := []struct{ Room int }{{1}}
+
+	rec := httptest.NewRecorder()
+	render(rec, httptest.NewRequest("GET", "/now/never-rendered", nil), "now.html", broken)
+	if rec.Code != http.StatusInternalServerError || strings.Contains(rec.Body.String(), "<html") {
+		t.Errorf("without a good page: %d %q", rec.Code, rec.Body.String())
+	}
+
+	good := get([]roomnow{{Room: "Grosser Saal"}})
+	if good.Code != http.StatusOK || !strings.Contains(good.Body.String(), "Grosser Saal") {
+		t.Fatalf("good page: %d %q", good.Code, good.Body.String())
+	}
+	rec = get(broken)
+	body := rec.Body.String()
+	if rec.Code != http.StatusOK || !strings.Contains(body, "Grosser Saal") || !strings.Contains(body, `<body>`+"\n"+`<p class="stale">`+translate("en", "renderfailed")) {
+		t.Errorf("fallback: %d %q", rec.Code, body)
+	}
+	if strings.Count(body, "</html>") != 1 {
+		t.Errorf("half-written page in fallback:\n%s", body)
+	}
+}
+
+func TestLastGoodEviction(t *testing.T) {
+	t.Cleanup(func() {
+		lastgoodpages.Lock()
+		defer lastgoodpages.Unlock()
+		clear(lastgoodpages.pages)
+	})
+	key := func(path string) string {
+		return lastgoodkey(httptest.NewRequest("GET", path, nil), "now.html", "en")
+	}
+	if key("/now?tz=UTC&x=1") != key("/now?x=2&tz=UTC") || key("/now?tz=UTC") == key("/now") {
+		t.Error("key depends on the wrong query parameters")
+	}
+	for i := range maxcachedpages {
+		storelastgood(key(fmt.Sprintf("/now/%d", i)), []byte("page"))
+	}
+	lastgood(key("/now/0"))
+	storelastgood(key("/now/new"), []byte("page"))
+	for path, want := range map[string]bool{"/now/0": true, "/now/1": false, "/now/2": true, "/now/new": true} {
+		if _, ok := lastgood(key(path)); ok != want {
+			t.Errorf("%s kept: %v", path, ok)
+		}
+	}
+}
+
+func TestTemplateFuncs(t *testing.T) {
+	defer setconf(*conf())
+	conf().BaseURL = "https://gpn.example.org/"
+	tmpl := template.Must(newtemplate("de", loc).Parse(`{{FormatTime .Start "Monday, 2. January (Mon, Jan) 15:04"}}|{{Duration .Length}}|{{Slug "Größer Saal"}}|{{FeedPath "room" "Größer Saal"}}|{{FeedURL (FeedPath "day" "2013-05-31")}}|{{Webcal (FeedPath "all" "")}}|{{Markdown .Desc}}`))
+	var buf bytes.Buffer
+	err := tmpl.Execute(&buf, map[string]any{
+		"Start":  time.Date(2013, 5, 31, 10, 0, 0, 0, loc),
+		"Length": 90 * time.Minute,
+		"Desc":   "# Intro\nSome **bold** and *em* `co*de*`, [a link](https://example.org/?a=1&b=2) <script>x</script>\n\n- one\n- two",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "Freitag, 31. Mai (Fr, Mai) 10:00|1h30m|groesser-saal|/rooms/groesser-saal.ics|https://gpn.example.org/all.ics?day=2013-05-31|webcal://gpn.example.org/all.ics|" +
+		"<h1>Intro</h1>\n<p>Some <strong>bold</strong> and <em>em</em> <code>co*de*</code>, <a href=\"https://example.org/?a=1&amp;b=2\">a link</a> &lt;script&gt;x&lt;/script&gt;</p>\n<ul>\n<li>one</li>\n<li>two</li>\n</ul>\n"
+	if buf.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", buf.String(), want)
+	}
+	if _, err := feedpath("stage", "x"); err == nil {
+		t.Error("no error for an unknown calendar kind")
+	}
+}
+
+func TestZonedPages(t *testing.T) {
+	defer published.Store(current())
+	defer func(f func() time.Time) { now = f }(now)
+	now = func() time.Time { return time.Date(2013, 5, 31, 10, 30, 0, 0, loc) }
+	publish(calendar{{Start: "20130531-1200", End: "20130531-1300", Title: "next", Place: "A"}})
+
+	for _, path := range []string{"/now", "/now?tz=UTC", "/now?tz=America/New_York", "/now?tz=UTC"} {
+		rec := httptest.NewRecorder()
+		handlenow(rec, httptest.NewRequest("GET", path, nil))
+		want := map[string]string{"/now": "Fri 12:00", "/now?tz=UTC": "Fri 10:00", "/now?tz=America/New_York": "Fri 06:00"}[path]
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("%s: %d, no %q in\n%s", path, rec.Code, want, rec.Body.String())
+		}
+	}
+}
+
+func TestIndexNowNext(t *testing.T) {
+	defer published.Store(current())
+	defer func(f func() time.Time) { now = f }(now)
+	defer resetpagecache()
+	publish(calendar{
+		{Start: "20130531-1000", End: "20130531-1100", Title: "running", Place: "A"},
+		{Start: "20130531-1130", End: "20130531-1200", Title: "upcoming", Place: "A"},
+		{Start: "20130531-1400", End: "20130531-1500", Title: "later", Place: "B"},
+	})
+	now = func() time.Time { return time.Date(2013, 5, 31, 10, 45, 0, 0, loc) }
+	resetpagecache()
+
+	rec := httptest.NewRecorder()
+	handle(rec, httptest.NewRequest("GET", "/", nil))
+	body := rec.Body.String()
+	for _, want := range []string{
+		`<meta http-equiv="refresh"`,
+		`running&#34;</a> (15 ` + translate("en", "minutesleft"),
+		`Fri 11:30 <a`, `upcoming&#34;</a> (` + translate("en", "in") + ` 45 min)`,
+		`Fri 14:00 <a`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("no %q in\n%s", want, body)
+		}
+	}
+}
+
+func TestStreamedCalendar(t *testing.T) {
+	defer published.Store(current())
+	c := calendar{}
+	for i := range 500 {
+		c = append(c, event{Start: fmt.Sprintf("20130531-%02d%02d", 10+i/60, i%60), Title: strings.Repeat("talk ", 20), Place: "A"})
+	}
+	publish(c)
+
+	rec := httptest.NewRecorder()
+	location("Alle").ServeHTTP(rec, httptest.NewRequest("GET", "/all.ics?duration", nil))
+	if !rec.Flushed || !bytes.Equal(rec.Body.Bytes(), current().schedule.ICalWith(requesticaloptions(url.Values{"duration": {""}}))) {
+		t.Errorf("streamed calendar differs or wasn't flushed (%d bytes)", rec.Body.Len())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := httptest.NewRequest("GET", "/all.ics?duration", nil).WithContext(ctx)
+	rec = httptest.NewRecorder()
+	w := newflushwriter(rec, r)
+	io.WriteString(w, "BEGIN")
+	cancel()
+	if err := current().schedule.WriteICal(w, defaulticaloptions()); !errors.Is(err, context.Canceled) || rec.Body.Len() != len("BEGIN") {
+		t.Errorf("writing to a gone client: %v, %d bytes", err, rec.Body.Len())
+	}
+}
+
+func TestIncrementalRebuild(t *testing.T) {
+	defer setconf(*conf())
+	defer published.Store(current())
+	c := calendar{
+		{Start: "20130531-1000", End: "20130531-1100", Title: "a", Place: "A"},
+		{Start: "20130531-1000", End: "20130531-1100", Title: "b", Place: "B"},
+	}
+	publish(c)
+	before := current()
+
+	c[1].Title = "b2"
+	publish(c)
+	after := current()
+	if &after.icals["A"][0] != &before.icals["A"][0] {
+		t.Error("unchanged room A was rendered again")
+	}
+	for _, l := range []location{"B", "Alle"} {
+		if bytes.Equal(after.icals[l], before.icals[l]) {
+			t.Errorf("changed calendar %s wasn't rendered again", l)
+		}
+	}
+
+	conf().Duration = true
+	publish(c)
+	if &current().icals["A"][0] == &after.icals["A"][0] || !strings.Contains(string(current().icals["A"]), "DURATION:") {
+		t.Error("room A not rendered again after a settings change")
+	}
+}
+
+func TestParallel(t *testing.T) {
+	var running, most atomic.Int32
+	done := make([]bool, 50)
+	parallel(len(done), 4, func(i int) {
+		n := running.Add(1)
+		for m := most.Load(); n > m && !most.CompareAndSwap(m, n); m = most.Load() {
+		}
+		time.Sleep(time.Millisecond)
+		done[i] = true
+		running.Add(-1)
+	})
+	if slices.Contains(done, false) {
+		t.Error("not every job ran")
+	}
+	if most.Load() > 4 {
+		t.Errorf("%d jobs at once with 4 workers", most.Load())
+	}
+	parallel(0, 4, func(int) { t.Error("job without work") })
+}
+
+func TestPooledRendering(t *testing.T) {
+	a := calendar{{Start: "20130531-1000", End: "20130531-1100", Title: strings.Repeat("long title ", 20), Place: "A"}}
+	b := calendar{{Start: "20130601-1000", End: "20130601-1100", Title: "b", Place: "B"}}
+	opt := icaloptions{stamp: "20130101T000000Z"}
+	first := a.ICalWith(opt)
+	want := bytes.Clone(first)
+	for range 10 {
+		b.ICalWith(opt)
+		b.FreeBusy("B")
+	}
+	if !bytes.Equal(first, want) {
+		t.Error("rendered calendar changed after its buffer went back to the pool")
+	}
+	if again := a.ICalWith(opt); !bytes.Equal(again, want) {
+		t.Errorf("rendering with a recycled buffer differs:\n%s\n%s", again, want)
+	}
+}
+
+type failingtransport struct{}
+
+func (failingtransport) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestTelegramErrorsHideToken(t *testing.T) {
+	bot, _ := newtelegrambot("123456:SECRET-TOKEN", "")
+	bot.client = &http.Client{Transport: failingtransport{}}
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	err := bot.call("getUpdates", nil, nil)
+	log.Println(err)
+	if err == nil || !strings.Contains(logged.String(), "connection refused") || strings.Contains(logged.String(), "SECRET-TOKEN") {
+		t.Errorf("logged %q", logged.String())
+	}
+}
+
+// xmppscript replays canned server stanzas and records what was sent.
+type xmppscript struct {
+	io.Reader
+	sent bytes.Buffer
+}
+
+func (s *xmppscript) Write(p []byte) (int, error) { return s.sent.Write(p) }
+func (s *xmppscript) Close() error                { return nil }
+
+func TestXMPPWaitsForJoin(t *testing.T) {
+	s := &xmppscript{Reader: strings.NewReader(`<iq type='error' id='bind'><error type='cancel'><conflict xmlns='urn:ietf:params:xml:ns:xmpp-stanzas'/></error></iq>`)}
+	c := &xmppconn{conn: s, dec: xml.NewDecoder(s)}
+	if err := c.bind(); err == nil || !strings.Contains(err.Error(), "conflict") {
+		t.Errorf("bind error: %v", err)
+	}
+
+	s = &xmppscript{Reader: strings.NewReader(`<iq type='result' id='bind'><bind xmlns='urn:ietf:params:xml:ns:xmpp-bind'><jid>bot@example.org/gpnsched</jid></bind></iq>` +
+		`<presence from='gpn@muc.example.org/alice'><x xmlns='http://jabber.org/protocol/muc#user'><item role='participant'/></x></presence>` +
+		`<presence from='other@muc.example.org/gpnsched'><x xmlns='http://jabber.org/protocol/muc#user'><status code='110'/></x></presence>` +
+		`<message from='gpn@muc.example.org' type='groupchat'><subject>GPN</subject></message>` +
+		`<presence from='gpn@muc.example.org/gpnsched'><x xmlns='http://jabber.org/protocol/muc#user'><item role='participant'/><status code='110'/></x></presence>` +
+		`<presence from='closed@muc.example.org/gpnsched' type='error'><error type='auth'><registration-required xmlns='urn:ietf:params:xml:ns:xmpp-stanzas'/></error></presence>`)}
+	c = &xmppconn{conn: s, dec: xml.NewDecoder(s)}
+	if err := c.bind(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.join("gpn@muc.example.org", "gpnsched"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.join("closed@muc.example.org", "gpnsched"); err == nil || !strings.Contains(err.Error(), "registration-required") {
+		t.Errorf("join error: %v", err)
+	}
+	if err := c.join("gpn@muc.example.org", "gpnsched"); err != io.EOF {
+		t.Errorf("joined without confirmation: %v", err)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+type sourceevents struct {
+	Name   string
+	Events calendar
+}
+
+func mergekey(e *event) string {
+	return strings.ToLower(strings.Join(strings.Fields(e.Title), " "))
+}
+
+// mergesources combines the events of several sources. Events with the same
+// normalized title are the same talk; the n-th occurrence in one source
+// matches the n-th occurrence (by start time) in the others. For each field,
+// conf().Merge may name the source whose value wins. Otherwise the first
+// source in configuration order with a non-empty value wins.
+func mergesources(sources []sourceevents) calendar {
+	if len(sources) == 1 {
+		return sources[0].Events
+	}
+
+	rank := map[string]int{}
+	for i, s := range sources {
+		rank[s.Name] = i
+	}
+	for field, name := range conf().Merge {
+		if _, ok := reflect.TypeOf(event{}).FieldByName(field); !ok {
+			log.Printf("merge: unknown field %q", field)
+		}
+		if _, ok := rank[name]; !ok {
+			log.Printf("merge: unknown or disabled source %q for %s", name, field)
+		}
+	}
+
+	type group struct {
+		first   int
+		matches []*event
+	}
+	var order []string
+	groups := map[string][]*group{}
+	for si, s := range sources {
+		seen := map[string]int{}
+		sorted := append(calendar(nil), s.Events...)
+		sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Starttime().Before(sorted[j].Starttime()) })
+		for i := range sorted {
+			e := &sorted[i]
+			key := mergekey(e)
+			n := seen[key]
+			seen[key]++
+			if n == len(groups[key]) {
+				if n == 0 {
+					order = append(order, key)
+				}
+				groups[key] = append(groups[key], &group{first: si, matches: make([]*event, len(sources))})
+			}
+			groups[key][n].matches[si] = e
+		}
+	}
+
+	ret := calendar{}
+	for _, key := range order {
+		for _, g := range groups[key] {
+			merged := *g.matches[g.first]
+			v := reflect.ValueOf(&merged).Elem()
+			for i := 0; i < v.NumField(); i++ {
+				field := v.Type().Field(i).Name
+				if si, ok := rank[conf().Merge[field]]; ok && g.matches[si] != nil {
+					if f := reflect.ValueOf(g.matches[si]).Elem().Field(i); !f.IsZero() {
+						v.Field(i).Set(f)
+						continue
+					}
+				}
+				if !v.Field(i).IsZero() {
+					continue
+				}
+				for _, e := range g.matches {
+					if e != nil && !reflect.ValueOf(e).Elem().Field(i).IsZero() {
+						v.Field(i).Set(reflect.ValueOf(e).Elem().Field(i))
+						break
+					}
+				}
+			}
+			ret = append(ret, merged)
+		}
+	}
+	return ret
+}
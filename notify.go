@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+type notifier interface {
+	notify(msgs []string) error
+}
+
+var notifiers []notifier
+
+func notifyall(msgs []string) {
+	if len(msgs) == 0 {
+		return
+	}
+	notifiersmutex.Lock()
+	ns := notifiers
+	notifiersmutex.Unlock()
+	for _, n := range ns {
+		if err := n.notify(msgs); err != nil {
+			log.Println("notify:", err)
+		}
+	}
+}
+
+func (e *event) Announcement() string {
+	return fmt.Sprintf("%s %s: %s", e.Starttime().In(loc).Format("Mon 15:04"), e.Place, e.Titlestring())
+}
+
+func (c change) Message() string {
+	switch c.Kind {
+	case added:
+		return "New: " + c.New.Announcement()
+	case removed:
+		return "Cancelled: " + c.Old.Announcement()
+	}
+	return fmt.Sprintf("Changed (%s): %s", strings.Join(c.Fields, ", "), c.New.Announcement())
+}
+
+type announcer struct {
+	previous  calendar
+	announced map[string]bool
+}
+
+func (a *announcer) changes(c calendar) {
+	if a.previous == nil {
+		a.previous = c
+		return
+	}
+	var msgs []string
+	for _, ch := range diffschedules(a.previous, c) {
+		if ch.event().Type != "break" {
+			msgs = append(msgs, ch.Message())
+		}
+	}
+	a.previous = c
+	notifyall(msgs)
+}
+
+func (a *announcer) upcoming(lead time.Duration) {
+	a.announced = map[string]bool{}
+	for t := range time.Tick(time.Minute) {
+		c := current().schedule
+		var msgs []string
+		for i := range c {
+			e := &c[i]
+			start := e.Starttime()
+			if e.Type == "break" || a.announced[e.UID()] || start.Before(t) || start.After(t.Add(lead)) {
+				continue
+			}
+			a.announced[e.UID()] = true
+			msgs = append(msgs, "Upcoming: "+e.Announcement())
+		}
+		notifyall(msgs)
+	}
+}
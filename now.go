@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+)
+
+type roomnow struct {
+	Room location
+	Now  []apievent
+	Next *apievent
+}
+
+func nownext(c calendar, t time.Time, tz *time.Location) (ret []roomnow) {
+	rooms := map[location]*roomnow{}
+	for _, e := range c {
+		if e.Place == "" {
+			continue
+		}
+		r := rooms[e.Place]
+		if r == nil {
+			r = &roomnow{Room: e.Place, Now: []apievent{}}
+			rooms[e.Place] = r
+		}
+		switch start := e.Starttime(); {
+		case !start.After(t) && e.Endtime().After(t):
+			r.Now = append(r.Now, newapievent(e, tz))
+		case start.After(t) && (r.Next == nil || start.Before(r.Next.StartTime)):
+			next := newapievent(e, tz)
+			r.Next = &next
+		}
+	}
+	for _, r := range rooms {
+		ret = append(ret, *r)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Room < ret[j].Room })
+	return
+}
+
+func (e apievent) Remaining() int {
+	return int(e.EndTime.Sub(now()).Minutes())
+}
+
+func (e apievent) Until() int {
+	return int(e.StartTime.Sub(now()).Minutes())
+}
+
+func handlenow(w http.ResponseWriter, r *http.Request) {
+	tz, err := requestlocation(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rooms := nownext(current().schedule, now(), tz)
+
+	if wantsjson(r) {
+		w.Header().Add("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rooms)
+		return
+	}
+	render(w, r, "now.html", rooms)
+}
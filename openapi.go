@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+)
+
+type apiparam struct {
+	Name        string
+	Description string
+}
+
+type apiendpoint struct {
+	Path    string
+	Summary string
+	Params  []apiparam
+	Result  any
+	Handler http.HandlerFunc
+}
+
+var apiendpoints = []apiendpoint{
+	{
+		Path:    "/api/events",
+		Summary: "All events of the current schedule",
+		Params: []apiparam{
+			{"language", "only events held in this language"},
+			{"track", "only events of this track"},
+			{"tz", "IANA time zone for StartTime and EndTime"},
+			{"from", "only events ending after this RFC 3339 time"},
+			{"until", "only events starting before this RFC 3339 time"},
+			{"offset", "skip this many events"},
+			{"limit", "return at most this many events"},
+		},
+		Result:  []apievent{},
+		Handler: apievents,
+	},
+	{
+		Path:    "/api/calendars",
+		Summary: "Every subscribable calendar: rooms, tracks, speakers and days",
+		Result:  []calendarfeed{},
+		Handler: handlecalendars,
+	},
+	{
+		Path:    "/search",
+		Summary: "Events matching a full text query",
+		Params: []apiparam{
+			{"q", "search terms"},
+			{"format", "json for a JSON response instead of HTML"},
+			{"tz", "IANA time zone for StartTime and EndTime"},
+			{"from", "only events ending after this RFC 3339 time"},
+			{"until", "only events starting before this RFC 3339 time"},
+			{"offset", "skip this many events"},
+			{"limit", "return at most this many events"},
+		},
+		Result:  []apievent{},
+		Handler: handlesearch,
+	},
+	{
+		Path:    "/now",
+		Summary: "Running and next event per room",
+		Params: []apiparam{
+			{"format", "json for a JSON response instead of HTML"},
+			{"tz", "IANA time zone for StartTime and EndTime"},
+		},
+		Result:  []roomnow{},
+		Handler: handlenow,
+	},
+	{
+		Path:    "/stats",
+		Summary: "Event counts and scheduled hours",
+		Result:  stats{},
+		Handler: handlestats,
+	},
+	{
+		Path:    "/infobeamer.json",
+		Summary: "Talks in the layout expected by info-beamer conference room packages",
+		Params: []apiparam{
+			{"room", "only talks in this room"},
+		},
+		Result:  []infobeamertalk{},
+		Handler: handleinfobeamer,
+	},
+}
+
+var timetype = reflect.TypeOf(time.Time{})
+
+func jsonschema(t reflect.Type) map[string]any {
+	if t == timetype {
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return jsonschema(t.Elem())
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": jsonschema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": jsonschema(t.Elem())}
+	case reflect.Struct:
+		props := map[string]any{}
+		jsonproperties(t, props)
+		return map[string]any{"type": "object", "properties": props}
+	}
+	return map[string]any{}
+}
+
+func jsonproperties(t reflect.Type, props map[string]any) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			jsonproperties(f.Type, props)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		name := f.Name
+		if tag, _, _ := strings.Cut(f.Tag.Get("json"), ","); tag == "-" {
+			continue
+		} else if tag != "" {
+			name = tag
+		}
+		props[name] = jsonschema(f.Type)
+	}
+}
+
+func openapi() map[string]any {
+	paths := map[string]any{}
+	for _, ep := range apiendpoints {
+		var params []any
+		for _, p := range ep.Params {
+			params = append(params, map[string]any{
+				"name":        p.Name,
+				"in":          "query",
+				"description": p.Description,
+				"schema":      map[string]any{"type": "string"},
+			})
+		}
+		op := map[string]any{
+			"summary": ep.Summary,
+			"responses": map[string]any{
+				"200": map[string]any{
+					"description": "OK",
+					"content": map[string]any{
+						"application/json": map[string]any{"schema": jsonschema(reflect.TypeOf(ep.Result))},
+					},
+				},
+			},
+		}
+		if params != nil {
+			op["parameters"] = params
+		}
+		paths[ep.Path] = map[string]any{"get": op}
+	}
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info":    map[string]any{"title": "gpnsched", "version": "1"},
+		"paths":   paths,
+	}
+}
+
+func handleopenapi(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(openapi())
+}
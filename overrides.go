@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"os"
+)
+
+type override struct {
+	Hidden bool
+	Set    map[string]any
+}
+
+func loadoverrides() (map[string]override, error) {
+	if conf().Overrides == "" {
+		return nil, nil
+	}
+	buf, err := os.ReadFile(conf().Overrides)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	overrides := map[string]override{}
+	if err := json.Unmarshal(buf, &overrides); err != nil {
+		return nil, err
+	}
+	return overrides, nil
+}
+
+func (o override) apply(e event) (event, error) {
+	buf, err := json.Marshal(e)
+	if err != nil {
+		return e, err
+	}
+	fields := map[string]any{}
+	if err := json.Unmarshal(buf, &fields); err != nil {
+		return e, err
+	}
+	for k, v := range o.Set {
+		fields[k] = v
+	}
+	if buf, err = json.Marshal(fields); err != nil {
+		return e, err
+	}
+	var patched event
+	err = json.Unmarshal(buf, &patched)
+	return patched, err
+}
+
+// applyoverrides patches or hides events by their upstream UID.
+func applyoverrides(events calendar) calendar {
+	overrides, err := loadoverrides()
+	if err != nil {
+		log.Println("loading overrides:", err)
+		return events
+	}
+	if len(overrides) == 0 {
+		return events
+	}
+
+	ret := calendar{}
+	for _, e := range events {
+		o, ok := overrides[e.UID()]
+		switch {
+		case !ok:
+		case o.Hidden:
+			continue
+		default:
+			patched, err := o.apply(e)
+			if err != nil {
+				log.Printf("override %s: %s", e.UID(), err)
+			} else {
+				e = patched
+			}
+		}
+		ret = append(ret, e)
+	}
+	return ret
+}
+
+func handleoverrides(w http.ResponseWriter, r *http.Request) {
+	if conf().Overrides == "" {
+		http.Error(w, "no override file configured", http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		overrides, err := loadoverrides()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if overrides == nil {
+			overrides = map[string]override{}
+		}
+		w.Header().Add("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(overrides)
+	case http.MethodPut:
+		buf, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := json.Unmarshal(buf, &map[string]override{}); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		tmp := conf().Overrides + ".tmp"
+		if err := os.WriteFile(tmp, buf, 0644); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := os.Rename(tmp, conf().Overrides); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		triggerrefresh()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
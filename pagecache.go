@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxcachedpages bounds the cache, as the base URL in the key comes from the
+// Host header.
+const maxcachedpages = 256
+
+type cachedpage struct {
+	body    []byte
+	expires time.Time
+}
+
+// pagecache holds rendered HTML pages for one snapshot. It is dropped when a
+// new snapshot is published or the templates are reloaded.
+var pagecache = struct {
+	sync.Mutex
+	snap  *snapshot
+	pages map[string]cachedpage
+}{}
+
+func resetpagecache() {
+	pagecache.Lock()
+	pagecache.snap, pagecache.pages = nil, nil
+	pagecache.Unlock()
+}
+
+func cachedpagefor(snap *snapshot, key string) (cachedpage, bool) {
+	pagecache.Lock()
+	defer pagecache.Unlock()
+	if pagecache.snap != snap {
+		pagecache.snap, pagecache.pages = snap, map[string]cachedpage{}
+	}
+	p, ok := pagecache.pages[key]
+	if ok && !p.expires.IsZero() && now().After(p.expires) {
+		return p, false
+	}
+	return p, ok
+}
+
+func storecachedpage(snap *snapshot, key string, p cachedpage) {
+	pagecache.Lock()
+	defer pagecache.Unlock()
+	if pagecache.snap == snap && len(pagecache.pages) < maxcachedpages {
+		pagecache.pages[key] = p
+	}
+}
+
+// rendercached renders a page that only depends on the snapshot, the
+// language and the base URL, and serves it from the cache until the next
+// sync, or for at most maxage if that is set. Requests for another time zone
+// and pages with a staleness banner bypass the cache. If rendering fails,
+// writepage falls back to the last good version.
+func rendercached(w http.ResponseWriter, r *http.Request, name string, maxage time.Duration, data func(*snapshot) any) {
+	snap := current()
+	if _, stale := staleness(); stale || r.URL.Query().Has("tz") {
+		render(w, r, name, data(snap))
+		return
+	}
+
+	lang := requestlanguage(r)
+	key := name + "\x00" + lang + "\x00" + baseurl(r)
+	p, ok := cachedpagefor(snap, key)
+	if !ok {
+		var buf bytes.Buffer
+		if err := executetemplate(&buf, lang, loc, name, data(snap)); err != nil {
+			writepage(w, r, name, lang, nil, err)
+			return
+		}
+		p = cachedpage{body: buf.Bytes()}
+		if maxage > 0 {
+			p.expires = now().Add(maxage)
+		}
+		storecachedpage(snap, key, p)
+	}
+	writepage(w, r, name, lang, p.body, nil)
+}
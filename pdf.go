@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	pdfwidth  = 842.0
+	pdfheight = 595.0
+	pdfmargin = 28.0
+)
+
+type pdfdocument struct {
+	objects [][]byte
+}
+
+func (d *pdfdocument) add(format string, args ...any) int {
+	d.objects = append(d.objects, []byte(fmt.Sprintf(format, args...)))
+	return len(d.objects)
+}
+
+func (d *pdfdocument) set(id int, format string, args ...any) {
+	d.objects[id-1] = []byte(fmt.Sprintf(format, args...))
+}
+
+func (d *pdfdocument) bytes(root int) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	offsets := make([]int, len(d.objects))
+	for i, obj := range d.objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(d.objects)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(d.objects)+1, root, xref)
+	return buf.Bytes()
+}
+
+// pdfstring encodes s as a WinAnsi literal string for the standard fonts.
+func pdfstring(s string) string {
+	var buf strings.Builder
+	buf.WriteByte('(')
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			buf.WriteByte('\\')
+			buf.WriteRune(r)
+		case r == '€':
+			buf.WriteByte(0x80)
+		case r == '–' || r == '—':
+			buf.WriteByte('-')
+		case r == '„' || r == '“' || r == '”':
+			buf.WriteByte('"')
+		case r >= 0x20 && r < 0x7f || r >= 0xa0 && r <= 0xff:
+			buf.WriteByte(byte(r))
+		case r == '\n' || r == '\t':
+			buf.WriteByte(' ')
+		default:
+			buf.WriteByte('?')
+		}
+	}
+	buf.WriteByte(')')
+	return buf.String()
+}
+
+// wraptext breaks s into lines fitting width at the given font size, using
+// an average Helvetica glyph width.
+func wraptext(s string, width, size float64) (lines []string) {
+	limit := int(width / (size * 0.52))
+	if limit < 1 {
+		limit = 1
+	}
+	line := ""
+	for _, word := range strings.Fields(s) {
+		for len([]rune(word)) > limit {
+			if line != "" {
+				lines = append(lines, line)
+				line = ""
+			}
+			lines = append(lines, string([]rune(word)[:limit]))
+			word = string([]rune(word)[limit:])
+		}
+		switch {
+		case line == "":
+			line = word
+		case len([]rune(line))+1+len([]rune(word)) <= limit:
+			line += " " + word
+		default:
+			lines = append(lines, line)
+			line = word
+		}
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return
+}
+
+type timetableday struct {
+	Day    time.Time
+	Rooms  []location
+	Events calendar
+}
+
+func timetabledays(c calendar) (ret []timetableday) {
+	index := map[string]int{}
+	for _, e := range c {
+		if e.Place == "" {
+			continue
+		}
+		day := conferenceday(e.Starttime())
+		key := day.Format("2006-01-02")
+		i, ok := index[key]
+		if !ok {
+			i = len(ret)
+			index[key] = i
+			ret = append(ret, timetableday{Day: day})
+		}
+		ret[i].Events = append(ret[i].Events, e)
+	}
+	for i := range ret {
+		seen := map[location]bool{}
+		for _, e := range ret[i].Events {
+			if !seen[e.Place] {
+				seen[e.Place] = true
+				ret[i].Rooms = append(ret[i].Rooms, e.Place)
+			}
+		}
+		sort.Slice(ret[i].Rooms, func(a, b int) bool { return ret[i].Rooms[a] < ret[i].Rooms[b] })
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Day.Before(ret[j].Day) })
+	return
+}
+
+func (d *timetableday) page() []byte {
+	var b bytes.Buffer
+	text := func(font string, size, x, y float64, s string) {
+		fmt.Fprintf(&b, "BT /%s %.1f Tf %.2f %.2f Td %s Tj ET\n", font, size, x, y, pdfstring(s))
+	}
+
+	first, last := d.Events[0].Starttime(), d.Events[0].Endtime()
+	for _, e := range d.Events {
+		if e.Starttime().Before(first) {
+			first = e.Starttime()
+		}
+		if e.Endtime().After(last) {
+			last = e.Endtime()
+		}
+	}
+	first = first.In(loc).Truncate(time.Hour)
+	if last.After(last.Truncate(time.Hour)) {
+		last = last.Truncate(time.Hour).Add(time.Hour)
+	}
+
+	top := pdfheight - pdfmargin - 40
+	bottom := pdfmargin
+	left := pdfmargin + 34
+	colwidth := (pdfwidth - pdfmargin - left) / float64(len(d.Rooms))
+	scale := (top - bottom) / last.Sub(first).Minutes()
+	y := func(t time.Time) float64 { return top - t.Sub(first).Minutes()*scale }
+
+	text("F2", 16, pdfmargin, pdfheight-pdfmargin-14, d.Day.Format("Monday, 2006-01-02"))
+	for i, room := range d.Rooms {
+		x := left + float64(i)*colwidth
+		text("F2", 9, x+3, top+6, wraptext(room.DisplayName(), colwidth-6, 9)[0])
+	}
+
+	b.WriteString("0.8 G 0.3 w\n")
+	for t := first; !t.After(last); t = t.Add(time.Hour) {
+		fmt.Fprintf(&b, "%.2f %.2f m %.2f %.2f l S\n", left, y(t), pdfwidth-pdfmargin, y(t))
+	}
+	for i := 0; i <= len(d.Rooms); i++ {
+		x := left + float64(i)*colwidth
+		fmt.Fprintf(&b, "%.2f %.2f m %.2f %.2f l S\n", x, top, x, bottom)
+	}
+	b.WriteString("0 g\n")
+	for t := first; t.Before(last); t = t.Add(time.Hour) {
+		text("F1", 8, pdfmargin, y(t)-8, t.In(loc).Format("15:04"))
+	}
+
+	for _, e := range d.Events {
+		col := sort.Search(len(d.Rooms), func(i int) bool { return d.Rooms[i] >= e.Place })
+		x := left + float64(col)*colwidth + 1.5
+		y0, y1 := y(e.Starttime()), y(e.Endtime())
+		w, h := colwidth-3, y0-y1
+		gray := 0.92
+		if e.Type == "break" {
+			gray = 0.97
+		}
+		fmt.Fprintf(&b, "%.2f g %.2f %.2f %.2f %.2f re f 0 G 0.5 w %.2f %.2f %.2f %.2f re S 0 g\n", gray, x, y1, w, h, x, y1, w, h)
+
+		fmt.Fprintf(&b, "q %.2f %.2f %.2f %.2f re W n\n", x, y1, w, h)
+		type line struct {
+			font string
+			size float64
+			text string
+		}
+		lines := []line{{"F1", 7, e.Starttime().In(loc).Format("15:04") + "-" + e.Endtime().In(loc).Format("15:04")}}
+		for _, l := range wraptext(e.Title, w-4, 8) {
+			lines = append(lines, line{"F2", 8, l})
+		}
+		for _, l := range wraptext(e.Speaker, w-4, 7) {
+			lines = append(lines, line{"F1", 7, l})
+		}
+		ty := y0 - 9
+		for _, l := range lines {
+			if ty < y1 {
+				break
+			}
+			text(l.font, l.size, x+2, ty, l.text)
+			ty -= l.size + 1.5
+		}
+		b.WriteString("Q\n")
+	}
+	return b.Bytes()
+}
+
+func rendertimetable(c calendar) []byte {
+	var d pdfdocument
+	catalog := d.add("")
+	pages := d.add("")
+	font := "<< /Type /Font /Subtype /Type1 /BaseFont /%s /Encoding /WinAnsiEncoding >>"
+	regular := d.add(font, "Helvetica")
+	bold := d.add(font, "Helvetica-Bold")
+	resources := d.add("<< /Font << /F1 %d 0 R /F2 %d 0 R >> >>", regular, bold)
+
+	var kids []string
+	addpage := func(content []byte) {
+		stream := d.add("<< /Length %d >>\nstream\n%sendstream", len(content), content)
+		page := d.add("<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %g %g] /Resources %d 0 R /Contents %d 0 R >>", pages, pdfwidth, pdfheight, resources, stream)
+		kids = append(kids, fmt.Sprintf("%d 0 R", page))
+	}
+	for _, day := range timetabledays(c) {
+		addpage(day.page())
+	}
+	if len(kids) == 0 {
+		addpage(nil)
+	}
+	d.set(pages, "<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(kids))
+	d.set(catalog, "<< /Type /Catalog /Pages %d 0 R >>", pages)
+	return d.bytes(catalog)
+}
+
+func handletimetable(w http.ResponseWriter, r *http.Request) {
+	pdf := current().timetable
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", `inline; filename="schedule.pdf"`)
+	w.Write(pdf)
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// splitprivate takes the events in rooms configured as private out of the
+// published schedule. Their calendars are only served with the room's token.
+func splitprivate(events calendar) (calendar, map[location]calendar) {
+	if len(conf().Private) == 0 {
+		return events, nil
+	}
+	public := calendar{}
+	private := map[location]calendar{}
+	for _, e := range events {
+		if _, ok := conf().Private[e.Place]; ok {
+			private[e.Place] = append(private[e.Place], e)
+		} else {
+			public = append(public, e)
+		}
+	}
+	return public, private
+}
+
+func authorized(r *http.Request, l location) bool {
+	token, ok := conf().Private[l]
+	if !ok {
+		return true
+	}
+	if t := r.URL.Query().Get("token"); t != "" {
+		return equal(t, token)
+	}
+	t, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && equal(t, token)
+}
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"strings"
+)
+
+// byte mode, error correction level M, versions 1-10
+var (
+	qrrawcodewords = []int{0, 26, 44, 70, 100, 134, 172, 196, 242, 292, 346}
+	qreccperblock  = []int{0, 10, 16, 26, 18, 24, 16, 18, 22, 22, 26}
+	qrblocks       = []int{0, 1, 1, 1, 2, 2, 4, 4, 4, 5, 5}
+	qralignment    = [][]int{nil, nil, {6, 18}, {6, 22}, {6, 26}, {6, 30}, {6, 34}, {6, 22, 38}, {6, 24, 42}, {6, 26, 46}, {6, 28, 50}}
+)
+
+type qrcode struct {
+	size     int
+	modules  [][]bool
+	function [][]bool
+}
+
+func gfmul(x, y byte) byte {
+	var z int
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11d)
+		z ^= int((y>>uint(i))&1) * int(x)
+	}
+	return byte(z)
+}
+
+func rsdivisor(degree int) []byte {
+	ret := make([]byte, degree)
+	ret[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range ret {
+			ret[j] = gfmul(ret[j], root)
+			if j+1 < degree {
+				ret[j] ^= ret[j+1]
+			}
+		}
+		root = gfmul(root, 2)
+	}
+	return ret
+}
+
+func rsremainder(data, divisor []byte) []byte {
+	ret := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ ret[0]
+		copy(ret, ret[1:])
+		ret[len(ret)-1] = 0
+		for i := range ret {
+			ret[i] ^= gfmul(divisor[i], factor)
+		}
+	}
+	return ret
+}
+
+func qrdatacodewords(version int) int {
+	return qrrawcodewords[version] - qreccperblock[version]*qrblocks[version]
+}
+
+func qrencodedata(data []byte, version int) []byte {
+	var bits []bool
+	appendbits := func(v, n int) {
+		for i := n - 1; i >= 0; i-- {
+			bits = append(bits, (v>>uint(i))&1 != 0)
+		}
+	}
+	appendbits(4, 4)
+	if version < 10 {
+		appendbits(len(data), 8)
+	} else {
+		appendbits(len(data), 16)
+	}
+	for _, b := range data {
+		appendbits(int(b), 8)
+	}
+
+	capacity := qrdatacodewords(version) * 8
+	appendbits(0, min(4, capacity-len(bits)))
+	appendbits(0, (8-len(bits)%8)%8)
+	for pad := 0xec; len(bits) < capacity; pad ^= 0xec ^ 0x11 {
+		appendbits(pad, 8)
+	}
+
+	ret := make([]byte, len(bits)/8)
+	for i, b := range bits {
+		if b {
+			ret[i/8] |= 1 << uint(7-i%8)
+		}
+	}
+	return ret
+}
+
+func qrinterleave(data []byte, version int) []byte {
+	numblocks := qrblocks[version]
+	ecclen := qreccperblock[version]
+	raw := qrrawcodewords[version]
+	numshort := numblocks - raw%numblocks
+	shortlen := raw/numblocks - ecclen
+
+	divisor := rsdivisor(ecclen)
+	var blocks, eccs [][]byte
+	for i, k := 0, 0; i < numblocks; i++ {
+		n := shortlen
+		if i >= numshort {
+			n++
+		}
+		blocks = append(blocks, data[k:k+n])
+		eccs = append(eccs, rsremainder(data[k:k+n], divisor))
+		k += n
+	}
+
+	var ret []byte
+	for i := 0; i <= shortlen; i++ {
+		for _, b := range blocks {
+			if i < len(b) {
+				ret = append(ret, b[i])
+			}
+		}
+	}
+	for i := 0; i < ecclen; i++ {
+		for _, e := range eccs {
+			ret = append(ret, e[i])
+		}
+	}
+	return ret
+}
+
+func (q *qrcode) set(x, y int, dark bool) {
+	q.modules[y][x] = dark
+	q.function[y][x] = true
+}
+
+func (q *qrcode) finder(cx, cy int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			x, y := cx+dx, cy+dy
+			if x < 0 || y < 0 || x >= q.size || y >= q.size {
+				continue
+			}
+			d := max(abs(dx), abs(dy))
+			q.set(x, y, d != 2 && d != 4)
+		}
+	}
+}
+
+func (q *qrcode) alignment(cx, cy int) {
+	for dy := -2; dy <= 2; dy++ {
+		for dx := -2; dx <= 2; dx++ {
+			q.set(cx+dx, cy+dy, max(abs(dx), abs(dy)) != 1)
+		}
+	}
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func qrformatbits(mask int) int {
+	data := 0<<3 | mask // level M
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	return (data<<10 | rem) ^ 0x5412
+}
+
+func qrversionbits(version int) int {
+	rem := version
+	for i := 0; i < 12; i++ {
+		rem = (rem << 1) ^ ((rem >> 11) * 0x1f25)
+	}
+	return version<<12 | rem
+}
+
+func (q *qrcode) format(mask int) {
+	bits := qrformatbits(mask)
+	bit := func(i int) bool { return (bits>>uint(i))&1 != 0 }
+	for i := 0; i <= 5; i++ {
+		q.set(8, i, bit(i))
+	}
+	q.set(8, 7, bit(6))
+	q.set(8, 8, bit(7))
+	q.set(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		q.set(14-i, 8, bit(i))
+	}
+	for i := 0; i < 8; i++ {
+		q.set(q.size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		q.set(8, q.size-15+i, bit(i))
+	}
+	q.set(8, q.size-8, true)
+}
+
+func (q *qrcode) functionpatterns(version int) {
+	for i := 0; i < q.size; i++ {
+		q.set(6, i, i%2 == 0)
+		q.set(i, 6, i%2 == 0)
+	}
+	q.finder(3, 3)
+	q.finder(q.size-4, 3)
+	q.finder(3, q.size-4)
+
+	pos := qralignment[version]
+	for i, x := range pos {
+		for j, y := range pos {
+			if i == 0 && j == 0 || i == 0 && j == len(pos)-1 || i == len(pos)-1 && j == 0 {
+				continue
+			}
+			q.alignment(x, y)
+		}
+	}
+
+	q.format(0)
+
+	if version >= 7 {
+		bits := qrversionbits(version)
+		for i := 0; i < 18; i++ {
+			dark := (bits>>uint(i))&1 != 0
+			a, b := q.size-11+i%3, i/3
+			q.set(a, b, dark)
+			q.set(b, a, dark)
+		}
+	}
+}
+
+func (q *qrcode) place(data []byte) {
+	i := 0
+	for right := q.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < q.size; vert++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vert
+				if (right+1)&2 == 0 {
+					y = q.size - 1 - vert
+				}
+				if !q.function[y][x] && i < len(data)*8 {
+					q.modules[y][x] = (data[i>>3]>>uint(7-i&7))&1 != 0
+					i++
+				}
+			}
+		}
+	}
+}
+
+func qrmask(mask, x, y int) bool {
+	switch mask {
+	case 0:
+		return (x+y)%2 == 0
+	case 1:
+		return y%2 == 0
+	case 2:
+		return x%3 == 0
+	case 3:
+		return (x+y)%3 == 0
+	case 4:
+		return (x/3+y/2)%2 == 0
+	case 5:
+		return x*y%2+x*y%3 == 0
+	case 6:
+		return (x*y%2+x*y%3)%2 == 0
+	}
+	return ((x+y)%2+x*y%3)%2 == 0
+}
+
+func (q *qrcode) applymask(mask int) {
+	for y := 0; y < q.size; y++ {
+		for x := 0; x < q.size; x++ {
+			if !q.function[y][x] && qrmask(mask, x, y) {
+				q.modules[y][x] = !q.modules[y][x]
+			}
+		}
+	}
+}
+
+func (q *qrcode) penalty() int {
+	ret := 0
+	dark := 0
+	get := func(x, y int, transpose bool) bool {
+		if transpose {
+			return q.modules[x][y]
+		}
+		return q.modules[y][x]
+	}
+	for _, transpose := range []bool{false, true} {
+		for y := 0; y < q.size; y++ {
+			run := 0
+			var pattern int
+			for x := 0; x < q.size; x++ {
+				c := get(x, y, transpose)
+				if x > 0 && c == get(x-1, y, transpose) {
+					run++
+					if run == 5 {
+						ret += 3
+					} else if run > 5 {
+						ret++
+					}
+				} else {
+					run = 1
+				}
+				pattern = (pattern<<1 | b2i(c)) & 0x7ff
+				if x >= 10 && (pattern == 0x5d0 || pattern == 0x05d) {
+					ret += 40
+				}
+			}
+		}
+	}
+	for y := 0; y < q.size; y++ {
+		for x := 0; x < q.size; x++ {
+			c := q.modules[y][x]
+			if c {
+				dark++
+			}
+			if x+1 < q.size && y+1 < q.size && c == q.modules[y][x+1] && c == q.modules[y+1][x] && c == q.modules[y+1][x+1] {
+				ret += 3
+			}
+		}
+	}
+	total := q.size * q.size
+	ret += ((abs(dark*20-total*10)+total-1)/total - 1) * 10
+	return ret
+}
+
+func b2i(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func newqrcode(text string) (*qrcode, error) {
+	data := []byte(text)
+	version := 1
+	for ; version < len(qrrawcodewords); version++ {
+		header := 12
+		if version >= 10 {
+			header = 20
+		}
+		if header+len(data)*8 <= qrdatacodewords(version)*8 {
+			break
+		}
+	}
+	if version == len(qrrawcodewords) {
+		return nil, errors.New("qr: data too long")
+	}
+
+	codewords := qrinterleave(qrencodedata(data, version), version)
+
+	size := version*4 + 17
+	var best *qrcode
+	bestpenalty := -1
+	for mask := 0; mask < 8; mask++ {
+		q := &qrcode{size: size}
+		for i := 0; i < size; i++ {
+			q.modules = append(q.modules, make([]bool, size))
+			q.function = append(q.function, make([]bool, size))
+		}
+		q.functionpatterns(version)
+		q.place(codewords)
+		q.applymask(mask)
+		q.format(mask)
+		if p := q.penalty(); bestpenalty < 0 || p < bestpenalty {
+			best, bestpenalty = q, p
+		}
+	}
+	return best, nil
+}
+
+func (q *qrcode) Image(scale int) image.Image {
+	const border = 4
+	n := (q.size + 2*border) * scale
+	img := image.NewGray(image.Rect(0, 0, n, n))
+	for y := 0; y < n; y++ {
+		for x := 0; x < n; x++ {
+			mx, my := x/scale-border, y/scale-border
+			if mx >= 0 && my >= 0 && mx < q.size && my < q.size && q.modules[my][mx] {
+				img.SetGray(x, y, color.Gray{0})
+			} else {
+				img.SetGray(x, y, color.Gray{255})
+			}
+		}
+	}
+	return img
+}
+
+func handleqr(w http.ResponseWriter, r *http.Request) {
+	l := location(strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/qr/"), ".png"))
+
+	_, ok := current().icals[l]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	q, err := newqrcode(webcalurl(r, l))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("Content-Type", "image/png")
+	png.Encode(w, q.Image(8))
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// roomcalendars groups events into the calendars that are published: one per
+// room and the combined one.
+func roomcalendars(events calendar) map[location]calendar {
+	rooms := map[location]calendar{"Alle": events}
+	for _, e := range events {
+		if e.Place != "" {
+			rooms[e.Place] = append(rooms[e.Place], e)
+		}
+	}
+	return rooms
+}
+
+// rendersettings identifies the configuration calendars are rendered with,
+// so that a changed setting still rebuilds everything.
+func rendersettings() string {
+	buf, err := json.Marshal(conf())
+	if err != nil {
+		// can't tell, so assume it changed
+		return strconv.FormatInt(time.Now().UnixNano(), 10)
+	}
+	sum := sha256.Sum256(buf)
+	return hex.EncodeToString(sum[:])
+}
+
+// renderchanged renders the calendars in rooms, reusing those of prev whose
+// events and settings are unchanged according to prevversions. That keeps the
+// cost of a sync, and the calendars whose ETags change, in proportion to what
+// actually changed. It returns the versions to compare the next sync against.
+// Calendars are rendered on a pool of renderworkers goroutines.
+func renderchanged(rooms map[location]calendar, opt icaloptions, prev map[location][]byte, prevversions map[location]string) (map[location][]byte, map[location]string) {
+	settings := rendersettings()
+	names := slices.Collect(maps.Keys(rooms))
+	icals := make([][]byte, len(names))
+	hashes := make([]string, len(names))
+	// Large rooms go first, so that none is left running alone at the end.
+	slices.SortFunc(names, func(a, b location) int { return len(rooms[b]) - len(rooms[a]) })
+	parallel(len(names), renderworkers(), func(i int) {
+		room, c := names[i], rooms[names[i]]
+		hash := sha256.New()
+		io.WriteString(hash, settings)
+		io.WriteString(hash, c.Version())
+		hashes[i] = hex.EncodeToString(hash.Sum(nil))
+		if ical, ok := prev[room]; ok && prevversions[room] == hashes[i] {
+			icals[i] = ical
+			return
+		}
+		icals[i] = c.ICalWith(opt)
+	})
+
+	rendered := map[location][]byte{}
+	versions := map[location]string{}
+	for i, room := range names {
+		rendered[room], versions[room] = icals[i], hashes[i]
+	}
+	return rendered, versions
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+func (e *event) recurrencekey() string {
+	start := e.Starttime()
+	return fmt.Sprintf("%s\x00%s\x00%s\x00%s\x00%s\x00%s\x00%s", e.Key(), e.Place, e.Type, e.Desc, e.Long_desc,
+		start.Format("15:04"), e.Endtime().Sub(start))
+}
+
+func (c calendar) Recurring() (calendar, map[string]string) {
+	groups := map[string]calendar{}
+	var order []string
+	for _, e := range c {
+		key := e.recurrencekey()
+		if groups[key] == nil {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], e)
+	}
+
+	ret := calendar{}
+	rrules := map[string]string{}
+	for _, key := range order {
+		g := groups[key].sorted()
+		if len(g) < 2 {
+			ret = append(ret, g...)
+			continue
+		}
+
+		days := func(i int) int {
+			y1, m1, d1 := g[i-1].Starttime().Date()
+			y2, m2, d2 := g[i].Starttime().Date()
+			return int(time.Date(y2, m2, d2, 0, 0, 0, 0, time.UTC).Sub(time.Date(y1, m1, d1, 0, 0, 0, 0, time.UTC)).Hours() / 24)
+		}
+		interval := days(1)
+		uniform := interval > 0
+		for i := 2; i < len(g) && uniform; i++ {
+			uniform = days(i) == interval
+		}
+		if !uniform {
+			ret = append(ret, g...)
+			continue
+		}
+
+		rule := fmt.Sprintf("FREQ=DAILY;COUNT=%d", len(g))
+		if interval > 1 {
+			rule = fmt.Sprintf("FREQ=DAILY;INTERVAL=%d;COUNT=%d", interval, len(g))
+		}
+		rrules[g[0].UID()] = rule
+		ret = append(ret, g[0])
+	}
+	return ret, rrules
+}
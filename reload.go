@@ -0,0 +1,116 @@
+package main
+
+import (
+	"log"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+)
+
+var configpath string
+
+// restartsettings are read once on startup, so changing them in the config
+// file only takes effect after a restart.
+var restartsettings = []string{"Listen", "TLS", "Listeners", "H2C", "Google", "CalDAVPush", "Telegram", "NotifyLead", "TemplateReload", "ConfigReload"}
+
+func configmodtime(path string) time.Time {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return fi.ModTime()
+}
+
+func watchconfig(path string, interval time.Duration) {
+	last := configmodtime(path)
+	for range time.Tick(interval) {
+		if mod := configmodtime(path); mod.After(last) {
+			last = mod
+			if err := reloadconfig(path); err != nil {
+				log.Println("not reloading config, keeping the previous one:")
+				log.Println(err)
+			}
+		}
+	}
+}
+
+// reloadconfig validates the config file and, only if it is free of
+// problems, replaces the running config in one step.
+func reloadconfig(path string) error {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	c, err := decodeconfig(path, buf, defaultconfig())
+	if err != nil {
+		return err
+	}
+	old := conf()
+	c.Listen, c.Listeners = old.Listen, old.Listeners
+	old1, new1 := reflect.ValueOf(*old), reflect.ValueOf(c)
+	for _, name := range restartsettings {
+		if !reflect.DeepEqual(old1.FieldByName(name).Interface(), new1.FieldByName(name).Interface()) {
+			log.Printf("config: %s changed, restart to apply", name)
+		}
+	}
+	if old.Admin.ClientCA != c.Admin.ClientCA {
+		log.Println("config: Admin.ClientCA changed, restart to apply")
+	}
+	setconf(c)
+	log.Println("config reloaded")
+
+	reloadsources()
+	if !reflect.DeepEqual(old.XMPP, c.XMPP) {
+		reloadxmpp()
+	}
+	if old.Templates != c.Templates || old.Language != c.Language {
+		if err := loadtemplates(); err != nil {
+			log.Println("reloading templates, keeping the previous ones:", err)
+		}
+	}
+	resetpagecache()
+	triggerrefresh()
+	return nil
+}
+
+// reloadsources picks up added and removed sources, keeping the status of
+// sources that are still configured, and their admin's enable toggle and
+// mirrored payload as long as they point to the same URL.
+func reloadsources() {
+	sourcesmutex.Lock()
+	defer sourcesmutex.Unlock()
+	previous := map[string]*sourcestatus{}
+	for _, s := range sourcestates {
+		previous[s.Name] = s
+	}
+	sourcestates = nil
+	for _, s := range configuredsources() {
+		st := &sourcestatus{source: s}
+		if p, ok := previous[s.Name]; ok {
+			st.LastFetch, st.LastError, st.Events = p.LastFetch, p.LastError, p.Events
+			if p.URL == s.URL {
+				st.Disabled = p.Disabled
+				st.upstream, st.changed = p.upstream, p.changed
+			}
+		}
+		sourcestates = append(sourcestates, st)
+	}
+}
+
+var notifiersmutex sync.Mutex
+
+func reloadxmpp() {
+	notifiersmutex.Lock()
+	defer notifiersmutex.Unlock()
+	var ns []notifier
+	for _, n := range notifiers {
+		if _, ok := n.(*xmppnotifier); !ok {
+			ns = append(ns, n)
+		}
+	}
+	if x := conf().XMPP; x.JID != "" {
+		ns = append(ns, newxmppnotifier(x.JID, x.Password, x.Server, x.Nick, x.Rooms))
+	}
+	notifiers = ns
+}
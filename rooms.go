@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+type roommetadata struct {
+	Name     string
+	Building string
+	Floor    string
+	Capacity int
+	Stream   string
+	C3nav    string
+	Lat, Lon float64
+}
+
+func (l location) Metadata() roommetadata {
+	m := conf().Rooms[l]
+	if m.Name == "" {
+		m.Name = string(l)
+	}
+	if m.C3nav == "" {
+		m.C3nav = conf().C3nav[l]
+	}
+	return m
+}
+
+func (l location) DisplayName() string {
+	return l.Metadata().Name
+}
+
+func (m roommetadata) Where() string {
+	var parts []string
+	for _, p := range []string{m.Floor, m.Building} {
+		if p != "" {
+			parts = append(parts, p)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (m roommetadata) Address() string {
+	if where := m.Where(); where != "" {
+		return m.Name + ", " + where
+	}
+	return m.Name
+}
+
+func (m roommetadata) Geo() string {
+	if m.Lat == 0 && m.Lon == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%f;%f", m.Lat, m.Lon)
+}
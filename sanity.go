@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+func (e *event) setend(t time.Time) {
+	e.End = t.In(e.Zone()).Format("20060102-1504")
+}
+
+// fixend corrects events that end before they start: night sessions with
+// the end on the wrong day are moved past midnight, anything else gets the
+// default duration.
+func (e *event) fixend() (ret []anomaly) {
+	if parsegpntime(e.Start, time.Time{}, e.Zone()).IsZero() {
+		return
+	}
+	start, end := e.Starttime(), e.Endtime()
+	if !end.Before(start) {
+		return
+	}
+	old := e.End
+	if next := end.AddDate(0, 0, 1); next.After(start) && next.Sub(start) <= 12*time.Hour {
+		e.setend(next)
+		return []anomaly{{Kind: "timestamp", Detail: fmt.Sprintf("end %q before the start, moved to the next day", old)}}
+	}
+	d := time.Duration(conf().DefaultDuration * float64(time.Minute))
+	e.setend(start.Add(d))
+	return []anomaly{{Kind: "timestamp", Detail: fmt.Sprintf("end %q before the start, assuming %s", old, humanduration(d))}}
+}
+
+// checkduration flags events outside MinDuration and MaxDuration, which are
+// almost certainly data errors, and with ClampDurations also shortens or
+// extends them to the limit.
+func (e *event) checkduration() (ret []anomaly) {
+	if e.End == "" {
+		return
+	}
+	start := e.Starttime()
+	d := e.Endtime().Sub(start)
+	shortest := time.Duration(conf().MinDuration * float64(time.Minute))
+	longest := time.Duration(conf().MaxDuration * float64(time.Minute))
+	limit := d
+	switch {
+	case shortest > 0 && d < shortest:
+		limit = shortest
+	case longest > 0 && d > longest:
+		limit = longest
+	default:
+		return
+	}
+	detail := fmt.Sprintf("lasts %s", humanduration(d))
+	if conf().ClampDurations {
+		e.setend(start.Add(limit))
+		detail += ", clamped to " + humanduration(limit)
+	}
+	return []anomaly{{Kind: "duration", Detail: detail}}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+type searchindex struct {
+	events calendar
+	tokens []string
+	posts  map[string][]int
+}
+
+func tokenize(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func newsearchindex(c calendar) *searchindex {
+	idx := &searchindex{events: c, posts: map[string][]int{}}
+	for i, e := range c {
+		for _, t := range tokenize(strings.Join([]string{e.Title, e.Speaker, e.Affiliation, e.Desc, e.Long_desc}, " ")) {
+			p := idx.posts[t]
+			if len(p) > 0 && p[len(p)-1] == i {
+				continue
+			}
+			if len(p) == 0 {
+				idx.tokens = append(idx.tokens, t)
+			}
+			idx.posts[t] = append(p, i)
+		}
+	}
+	sort.Strings(idx.tokens)
+	return idx
+}
+
+func levenshtein(a, b []rune) int {
+	row := make([]int, len(b)+1)
+	for j := range row {
+		row[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		prev := row[0]
+		row[0] = i
+		for j := 1; j <= len(b); j++ {
+			cur := row[j]
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			row[j] = min(row[j]+1, row[j-1]+1, prev+cost)
+			prev = cur
+		}
+	}
+	return row[len(b)]
+}
+
+func maxdistance(term []rune) int {
+	switch {
+	case len(term) >= 8:
+		return 2
+	case len(term) >= 4:
+		return 1
+	}
+	return 0
+}
+
+func (idx *searchindex) lookup(term string) map[int]bool {
+	ret := map[int]bool{}
+	for i := sort.SearchStrings(idx.tokens, term); i < len(idx.tokens) && strings.HasPrefix(idx.tokens[i], term); i++ {
+		for _, e := range idx.posts[idx.tokens[i]] {
+			ret[e] = true
+		}
+	}
+
+	r := []rune(term)
+	dist := maxdistance(r)
+	if dist == 0 {
+		return ret
+	}
+	for _, t := range idx.tokens {
+		tr := []rune(t)
+		if len(tr) < len(r)-dist || len(tr) > len(r)+dist || levenshtein(r, tr) > dist {
+			continue
+		}
+		for _, e := range idx.posts[t] {
+			ret[e] = true
+		}
+	}
+	return ret
+}
+
+func (idx *searchindex) Search(q string) calendar {
+	ret := calendar{}
+	terms := tokenize(q)
+	if idx == nil || len(terms) == 0 {
+		return ret
+	}
+
+	hits := idx.lookup(terms[0])
+	for _, t := range terms[1:] {
+		next := idx.lookup(t)
+		for e := range hits {
+			if !next[e] {
+				delete(hits, e)
+			}
+		}
+	}
+
+	for i, e := range idx.events {
+		if hits[i] {
+			ret = append(ret, e)
+		}
+	}
+	return ret
+}
+
+func wantsjson(r *http.Request) bool {
+	return r.URL.Query().Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+func handlesearch(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query().Get("q")
+
+	results := current().index.Search(q)
+
+	if wantsjson(r) {
+		writeevents(w, r, results)
+		return
+	}
+	render(w, r, "search.html", struct {
+		Query   string
+		Results calendar
+	}{q, results})
+}
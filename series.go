@@ -0,0 +1,86 @@
+package main
+
+import (
+	"log"
+	"regexp"
+	"strings"
+)
+
+func seriesregexp() *regexp.Regexp {
+	if conf().SeriesPattern == "" {
+		return nil
+	}
+	re, err := regexp.Compile(conf().SeriesPattern)
+	if err != nil {
+		log.Println("invalid series pattern:", err)
+		return nil
+	}
+	return re
+}
+
+func (e *event) SeriesKey(re *regexp.Regexp) string {
+	if e.Series != "" {
+		return e.Series
+	}
+	if re == nil {
+		return ""
+	}
+	matches := re.FindAllStringIndex(e.Title, -1)
+	if matches == nil {
+		return ""
+	}
+	var b strings.Builder
+	last := 0
+	for _, m := range matches {
+		b.WriteString(e.Title[last:m[0]])
+		last = m[1]
+	}
+	b.WriteString(e.Title[last:])
+	return strings.TrimSpace(b.String()) + "\x00" + e.Speaker
+}
+
+func (c calendar) Parts() map[string]calendar {
+	re := seriesregexp()
+	series := map[string]calendar{}
+	for _, e := range c {
+		if key := e.SeriesKey(re); key != "" {
+			series[key] = append(series[key], e)
+		}
+	}
+
+	ret := map[string]calendar{}
+	for _, parts := range series {
+		if len(parts) < 2 {
+			continue
+		}
+		parts = parts.sorted()
+		for _, e := range parts {
+			ret[e.UID()] = parts
+		}
+	}
+	return ret
+}
+
+func (c calendar) Related() map[string][]string {
+	ret := map[string][]string{}
+	for uid, parts := range c.Parts() {
+		if _, done := ret[uid]; done {
+			continue
+		}
+		uids := make([]string, len(parts))
+		for i := range parts {
+			uids[i] = parts[i].UID()
+		}
+		for _, u := range uids {
+			if _, done := ret[u]; done {
+				continue
+			}
+			for _, other := range uids {
+				if other != u {
+					ret[u] = append(ret[u], other)
+				}
+			}
+		}
+	}
+	return ret
+}
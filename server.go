@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+func newserver(addr string, h http.Handler) *http.Server {
+	var p http.Protocols
+	p.SetHTTP1(true)
+	p.SetHTTP2(true)
+	// h2c is only safe behind a proxy that strips client supplied upgrades.
+	p.SetUnencryptedHTTP2(conf().H2C)
+	return &http.Server{Addr: addr, Handler: h, Protocols: &p}
+}
+
+type requestidkey struct{}
+
+func requestid(r *http.Request) string {
+	id, _ := r.Context().Value(requestidkey{}).(string)
+	return id
+}
+
+func newrequestid() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// validrequestid accepts the IDs proxies commonly generate, but nothing that
+// could mess up a log line.
+func validrequestid(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+type loggingwriter struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (w *loggingwriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *loggingwriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.size += n
+	return n, err
+}
+
+func (w *loggingwriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// loggeduri is the request URI as written to the logs, with the credentials
+// some calendar URLs carry cut out.
+func loggeduri(r *http.Request) string {
+	for _, personal := range []string{"/feeds/", "/angels/"} {
+		if strings.HasPrefix(r.URL.Path, personal) {
+			return personal + "…"
+		}
+	}
+	if q := r.URL.Query(); q.Has("token") {
+		q.Set("token", "redacted")
+		u := *r.URL
+		u.RawQuery = q.Encode()
+		return u.RequestURI()
+	}
+	return r.URL.RequestURI()
+}
+
+// withrequestid tags every request with an X-Request-ID, taken from the
+// client or proxy if present, and writes an access log line carrying it.
+// Plain text error responses get the ID appended, so users can quote it.
+func withrequestid(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validrequestid(id) {
+			id = newrequestid()
+		}
+		w.Header().Set("X-Request-ID", id)
+		r = r.WithContext(context.WithValue(r.Context(), requestidkey{}, id))
+
+		start := time.Now()
+		lw := &loggingwriter{ResponseWriter: w}
+		h.ServeHTTP(lw, r)
+		if lw.status >= 400 && strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+			n, _ := fmt.Fprintf(w, "request id: %s\n", id)
+			lw.size += n
+		}
+		if lw.status == 0 {
+			lw.status = http.StatusOK
+		}
+		countsubscriber(r, lw.status)
+		log.Printf("%s %s %s %s %d %d %s", id, r.RemoteAddr, r.Method, loggeduri(r), lw.status, lw.size, time.Since(start).Round(time.Millisecond))
+	})
+}
+
+// withrecover turns handler panics into a logged stack trace and a 500
+// instead of a silently dropped connection.
+func withrecover(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			if p == http.ErrAbortHandler {
+				panic(p)
+			}
+			log.Printf("%s panic serving %s %s: %v\n%s", requestid(r), r.Method, loggeduri(r), p, debug.Stack())
+			if lw, ok := w.(*loggingwriter); !ok || lw.status == 0 {
+				http.Error(w, "internal server error", http.StatusInternalServerError)
+			}
+		}()
+		h.ServeHTTP(w, r)
+	})
+}
+
+// withcleanpath permanently redirects paths with doubled slashes or dot
+// segments to their clean form. http.ServeMux would do that too, but with a
+// temporary redirect on some Go versions, and calendar clients only update
+// subscriptions on permanent ones.
+func withcleanpath(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := r.URL.Path
+		if p == "" || p[0] != '/' {
+			h.ServeHTTP(w, r)
+			return
+		}
+		clean := path.Clean(p)
+		if strings.HasSuffix(p, "/") && clean != "/" {
+			clean += "/"
+		}
+		if clean == p {
+			h.ServeHTTP(w, r)
+			return
+		}
+		u := *r.URL
+		u.Path, u.RawPath = clean, ""
+		http.Redirect(w, r, u.RequestURI(), http.StatusMovedPermanently)
+	})
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"os/exec"
+	"slices"
+	"strings"
+)
+
+// signcalendar makes a detached, ASCII armored OpenPGP signature. Go's
+// standard library has no OpenPGP, so this runs gpg, which needs the
+// configured key without a passphrase.
+var signcalendar = func(ical []byte) ([]byte, error) {
+	args := []string{"--batch", "--yes", "--armor", "--detach-sign", "--local-user", conf().Sign.Key}
+	if conf().Sign.Home != "" {
+		args = append([]string{"--homedir", conf().Sign.Home}, args...)
+	}
+	cmd := exec.Command("gpg", args...)
+	cmd.Stdin = bytes.NewReader(ical)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	sig, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("gpg: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return sig, nil
+}
+
+// signcalendars signs every rendered calendar. As unchanged schedules render
+// to the same bytes, the previous signatures are kept as long as the version
+// doesn't change.
+func signcalendars(version string, icals ...map[location][]byte) map[location][]byte {
+	if conf().Sign.Key == "" {
+		return nil
+	}
+	prev := current()
+	if prev.version == version && prev.signatures != nil {
+		return prev.signatures
+	}
+	all := map[location][]byte{}
+	for _, m := range icals {
+		maps.Copy(all, m)
+	}
+	names := slices.Collect(maps.Keys(all))
+	sigs := make([][]byte, len(names))
+	parallel(len(names), renderworkers(), func(i int) {
+		l, ical := names[i], all[names[i]]
+		if sig, ok := prev.signatures[l]; ok && (bytes.Equal(prev.icals[l], ical) || bytes.Equal(prev.privateics[l], ical)) {
+			sigs[i] = sig
+			return
+		}
+		sig, err := signcalendar(ical)
+		if err != nil {
+			log.Printf("signing %s: %v", l, err)
+			return
+		}
+		sigs[i] = sig
+	})
+	ret := map[location][]byte{}
+	for i, l := range names {
+		if sigs[i] != nil {
+			ret[l] = sigs[i]
+		}
+	}
+	return ret
+}
+
+func (l location) servesignature(w http.ResponseWriter, r *http.Request) {
+	if !authorized(r, l) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	sig, ok := current().signatures[l]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/pgp-signature")
+	w.Write(sig)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// nfc composes the combining marks that show up in room and event names
+// from Latin base letters. It is not a full Unicode NFC implementation, the
+// standard library has no normalization tables.
+var nfc = map[rune]map[rune]rune{
+	'̀': {'A': 'À', 'E': 'È', 'I': 'Ì', 'O': 'Ò', 'U': 'Ù', 'a': 'à', 'e': 'è', 'i': 'ì', 'o': 'ò', 'u': 'ù'},
+	'́': {'A': 'Á', 'E': 'É', 'I': 'Í', 'O': 'Ó', 'U': 'Ú', 'Y': 'Ý', 'a': 'á', 'e': 'é', 'i': 'í', 'o': 'ó', 'u': 'ú', 'y': 'ý', 'C': 'Ć', 'c': 'ć', 'N': 'Ń', 'n': 'ń', 'S': 'Ś', 's': 'ś', 'Z': 'Ź', 'z': 'ź'},
+	'̂': {'A': 'Â', 'E': 'Ê', 'I': 'Î', 'O': 'Ô', 'U': 'Û', 'a': 'â', 'e': 'ê', 'i': 'î', 'o': 'ô', 'u': 'û'},
+	'̃': {'A': 'Ã', 'N': 'Ñ', 'O': 'Õ', 'a': 'ã', 'n': 'ñ', 'o': 'õ'},
+	'̈': {'A': 'Ä', 'E': 'Ë', 'I': 'Ï', 'O': 'Ö', 'U': 'Ü', 'a': 'ä', 'e': 'ë', 'i': 'ï', 'o': 'ö', 'u': 'ü', 'y': 'ÿ'},
+	'̊': {'A': 'Å', 'a': 'å', 'U': 'Ů', 'u': 'ů'},
+	'̌': {'C': 'Č', 'c': 'č', 'E': 'Ě', 'e': 'ě', 'R': 'Ř', 'r': 'ř', 'S': 'Š', 's': 'š', 'Z': 'Ž', 'z': 'ž'},
+	'̧': {'C': 'Ç', 'c': 'ç'},
+}
+
+var transliterations = map[rune]string{
+	'ä': "ae", 'ö': "oe", 'ü': "ue", 'Ä': "ae", 'Ö': "oe", 'Ü': "ue", 'ß': "ss",
+	'æ': "ae", 'ø': "oe", 'å': "aa", 'Æ': "ae", 'Ø': "oe", 'Å': "aa",
+}
+
+// base maps composed letters back to their base letter for slugs.
+var base = map[rune]rune{}
+
+func init() {
+	for _, composed := range nfc {
+		for b, c := range composed {
+			base[c] = unicode.ToLower(b)
+		}
+	}
+}
+
+func normalizename(s string) string {
+	var ret []rune
+	for _, r := range s {
+		if n := len(ret); n > 0 {
+			if c, ok := nfc[r][ret[n-1]]; ok {
+				ret[n-1] = c
+				continue
+			}
+		}
+		ret = append(ret, r)
+	}
+	return strings.Join(strings.Fields(string(ret)), " ")
+}
+
+func slugify(s string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range normalizename(s) {
+		if t, ok := transliterations[r]; ok {
+			b.WriteString(t)
+			dash = false
+			continue
+		}
+		if c, ok := base[r]; ok {
+			r = c
+		}
+		r = unicode.ToLower(r)
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			b.WriteRune(r)
+			dash = false
+		} else if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	return strings.TrimSuffix(b.String(), "-")
+}
+
+func (l *location) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	*l = location(normalizename(s))
+	return nil
+}
+
+func (l location) Slug() string {
+	return slugify(string(l))
+}
+
+func roombyslug(slug string) (location, bool) {
+	snap := current()
+	var rooms []location
+	for l := range snap.icals {
+		rooms = append(rooms, l)
+	}
+	for l := range snap.privateics {
+		rooms = append(rooms, l)
+	}
+	sort.Slice(rooms, func(i, j int) bool { return rooms[i] < rooms[j] })
+	for _, l := range rooms {
+		if l.Slug() == slug {
+			return l, true
+		}
+	}
+	return "", false
+}
+
+func roompath(l location) string {
+	return "/rooms/" + l.Slug() + ".ics"
+}
+
+// redirectroom sends requests for name, which may be a raw room name, a slug
+// or either with a .ics suffix or trailing slashes, to the canonical room URL.
+func redirectroom(w http.ResponseWriter, r *http.Request, name string) {
+	name = strings.TrimSuffix(strings.Trim(name, "/"), ".ics")
+	l, ok := roombyslug(slugify(name))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	u := *r.URL
+	u.Path = roompath(l)
+	http.Redirect(w, r, u.String(), http.StatusMovedPermanently)
+}
+
+func handlerooms(w http.ResponseWriter, r *http.Request) {
+	path, signature := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/rooms/"), ".asc")
+	slug, ok := strings.CutSuffix(path, ".ics")
+	if signature && (!ok || slugify(slug) != slug) {
+		http.NotFound(w, r)
+		return
+	}
+	if !ok || slugify(slug) != slug {
+		redirectroom(w, r, strings.TrimPrefix(r.URL.Path, "/rooms/"))
+		return
+	}
+	l, ok := roombyslug(slug)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if signature {
+		l.servesignature(w, r)
+		return
+	}
+	l.ServeHTTP(w, r)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// snapshot is everything derived from one fetched schedule. It is never
+// modified once published, so handlers can use it without locking and always
+// see a consistent state, even while a sync replaces it.
+type snapshot struct {
+	schedule   calendar
+	index      *searchindex
+	icals      map[location][]byte
+	private    map[location]calendar
+	privateics map[location][]byte
+	timetable  []byte
+	version    string
+	stamp      string
+	signatures map[location][]byte
+	checksums  []byte
+	images     map[string]string
+
+	// roomversions tells which calendars the next sync can reuse.
+	roomversions map[location]string
+}
+
+var published atomic.Pointer[snapshot]
+
+func init() {
+	published.Store(&snapshot{
+		schedule: calendar{},
+		index:    newsearchindex(nil),
+		icals:    map[location][]byte{},
+	})
+}
+
+func current() *snapshot {
+	return published.Load()
+}
+
+// defaultstamp is the DTSTAMP of newly rendered calendars. SOURCE_DATE_EPOCH
+// pins it for reproducible output, e.g. of the generate command.
+func defaultstamp() string {
+	if s, err := strconv.ParseInt(os.Getenv("SOURCE_DATE_EPOCH"), 10, 64); err == nil {
+		return icaldatetime(time.Unix(s, 0))
+	}
+	return icaldatetime(time.Now())
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+type source struct {
+	Name     string
+	URL      string
+	Disabled bool
+}
+
+type sourcestatus struct {
+	source
+	LastFetch time.Time
+	LastError string
+	Events    int
+
+	// upstream is the last successfully fetched payload, for /upstream.json
+	upstream []byte
+	changed  time.Time
+}
+
+type syncstatus struct {
+	LastSync  time.Time
+	LastError string
+	Sources   []sourcestatus
+}
+
+var (
+	sourcesmutex  = sync.Mutex{}
+	sourcestates  []*sourcestatus
+	lastsync      time.Time
+	lastsyncerror string
+	refresh       = make(chan struct{}, 1)
+)
+
+func configuredsources() []source {
+	if len(conf().Sources) > 0 {
+		return conf().Sources
+	}
+	return []source{{Name: "default", URL: conf().Source}}
+}
+
+func initsources() {
+	sourcesmutex.Lock()
+	defer sourcesmutex.Unlock()
+	sourcestates = nil
+	for _, s := range configuredsources() {
+		sourcestates = append(sourcestates, &sourcestatus{source: s})
+	}
+}
+
+// fetchsources fetches all enabled sources. If any of them fails, nothing is
+// returned, so that a broken source doesn't make its events vanish.
+func fetchsources() (calendar, error) {
+	sourcesmutex.Lock()
+	states := append([]*sourcestatus(nil), sourcestates...)
+	sourcesmutex.Unlock()
+
+	var fetched []sourceevents
+	var anomalies []anomaly
+	var errs []error
+	enabled := 0
+	for _, s := range states {
+		sourcesmutex.Lock()
+		src := s.source
+		sourcesmutex.Unlock()
+		if src.Disabled {
+			continue
+		}
+		enabled++
+
+		buf, err := readsource(src.URL)
+		var c calendar
+		var found []anomaly
+		if err == nil {
+			c, found, err = parseschedule(buf)
+		}
+		for i := range c {
+			c[i].Source = src.Name
+		}
+		for _, a := range found {
+			a.Source = src.Name
+			anomalies = append(anomalies, a)
+		}
+		sourcesmutex.Lock()
+		s.LastFetch = time.Now()
+		s.LastError = ""
+		if err != nil {
+			s.LastError = err.Error()
+			errs = append(errs, fmt.Errorf("%s: %w", src.Name, err))
+		} else {
+			s.Events = len(c)
+			if !bytes.Equal(s.upstream, buf) {
+				s.upstream, s.changed = buf, s.LastFetch
+			}
+		}
+		sourcesmutex.Unlock()
+		fetched = append(fetched, sourceevents{src.Name, c})
+	}
+	if enabled == 0 {
+		return nil, errors.New("all sources are disabled")
+	}
+	setanomalies(anomalies)
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return mergesources(fetched), nil
+}
+
+// loadschedule fetches source, or if it is empty all configured sources
+// merged, the way a sync does, for the offline commands.
+func loadschedule(source string) (calendar, error) {
+	if source == "" {
+		initsources()
+		return fetchsources()
+	}
+	buf, err := readsource(source)
+	if err != nil {
+		return nil, err
+	}
+	events, _, err := parseschedule(buf)
+	return events, err
+}
+
+func readsource(source string) ([]byte, error) {
+	r, err := openschedule(source)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+func setsyncstatus(err error) {
+	sourcesmutex.Lock()
+	defer sourcesmutex.Unlock()
+	lastsyncerror = ""
+	if err != nil {
+		lastsyncerror = err.Error()
+	} else {
+		lastsync = time.Now()
+	}
+}
+
+func currentsyncstatus() syncstatus {
+	sourcesmutex.Lock()
+	defer sourcesmutex.Unlock()
+	s := syncstatus{LastSync: lastsync, LastError: lastsyncerror}
+	for _, st := range sourcestates {
+		s.Sources = append(s.Sources, *st)
+	}
+	return s
+}
+
+func togglesource(name string) bool {
+	sourcesmutex.Lock()
+	defer sourcesmutex.Unlock()
+	for _, s := range sourcestates {
+		if s.Name == name {
+			s.Disabled = !s.Disabled
+			return true
+		}
+	}
+	return false
+}
+
+func triggerrefresh() {
+	select {
+	case refresh <- struct{}{}:
+	default:
+	}
+}
+
+func waitrefresh() {
+	select {
+	case <-time.After(refreshinterval(time.Now())):
+	case <-refresh:
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+type speaker struct {
+	Name  string
+	Talks calendar
+}
+
+func speakers(c calendar) (ret []speaker) {
+	index := map[string]int{}
+	for _, e := range c {
+		for _, name := range e.Speakers() {
+			i, ok := index[name]
+			if !ok {
+				i = len(ret)
+				index[name] = i
+				ret = append(ret, speaker{Name: name})
+			}
+			ret[i].Talks = append(ret[i].Talks, e)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return strings.ToLower(ret[i].Name) < strings.ToLower(ret[j].Name)
+	})
+	for _, s := range ret {
+		sort.Slice(s.Talks, func(i, j int) bool {
+			return s.Talks[i].Starttime().Before(s.Talks[j].Starttime())
+		})
+	}
+	return
+}
+
+// ImagePath is the avatar from a talk the speaker gives alone, as the image
+// of a talk with several speakers could show any of them.
+func (s speaker) ImagePath() string {
+	for _, e := range s.Talks {
+		if p := e.SpeakerImagePath(); p != "" && len(e.Speakers()) == 1 {
+			return p
+		}
+	}
+	return ""
+}
+
+func (c calendar) Speaker(name string) (ret calendar) {
+	for _, e := range c {
+		for _, s := range e.Speakers() {
+			if s == name {
+				ret = append(ret, e)
+				break
+			}
+		}
+	}
+	return
+}
+
+func (c calendar) Event(uid string) *event {
+	for i := range c {
+		if c[i].UID() == uid {
+			return &c[i]
+		}
+	}
+	return nil
+}
+
+func handlespeakers(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/speakers")
+	if name == "" || name == "/" {
+		rendercached(w, r, "speakers.html", 0, func(snap *snapshot) any {
+			return speakers(snap.schedule)
+		})
+		return
+	}
+
+	name = strings.TrimSuffix(name[1:], ".ics")
+	c := current().schedule.Speaker(name)
+	if len(c) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	if !strings.HasSuffix(r.URL.Path, ".ics") {
+		render(w, r, "speakers.html", []speaker{{name, c}})
+		return
+	}
+	ical := c.ICal()
+	w.Header().Add("Content-Type", "text/calendar")
+	w.Write(ical)
+}
+
+func handleevent(w http.ResponseWriter, r *http.Request) {
+	c := current().schedule
+	e := c.Event(strings.TrimPrefix(r.URL.Path, "/events/"))
+	var parts calendar
+	if e != nil {
+		parts = c.Parts()[e.UID()]
+	}
+	if e == nil {
+		http.NotFound(w, r)
+		return
+	}
+	render(w, r, "event.html", struct {
+		*event
+		Parts calendar
+	}{e, parts})
+}
@@ -0,0 +1,11 @@
+package main
+
+import (
+	"embed"
+	"net/http"
+)
+
+//go:embed static
+var embeddedstatic embed.FS
+
+var staticfiles = http.FileServer(http.FS(embeddedstatic))
@@ -0,0 +1,31 @@
+document.addEventListener("DOMContentLoaded", function() {
+	document.querySelectorAll("input[readonly]").forEach(function(input) {
+		input.addEventListener("click", function() {
+			input.select();
+			if (navigator.clipboard) {
+				navigator.clipboard.writeText(input.value);
+			}
+		});
+	});
+});
+
+function pad(n) {
+	return n < 10 ? "0" + n : "" + n;
+}
+
+document.addEventListener("DOMContentLoaded", function() {
+	document.querySelectorAll(".countdown").forEach(function(el) {
+		var start = new Date(el.dataset.start);
+		var tick = function() {
+			var s = Math.max(0, Math.floor((start - new Date()) / 1000));
+			if (s == 0) {
+				location.reload();
+				return;
+			}
+			el.textContent = Math.floor(s / 86400) + "d " + pad(Math.floor(s / 3600) % 24) + "h " +
+				pad(Math.floor(s / 60) % 60) + "m " + pad(s % 60) + "s";
+			setTimeout(tick, 1000);
+		};
+		tick();
+	});
+});
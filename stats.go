@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+type statcount struct {
+	Events int
+	Hours  float64
+}
+
+type stats struct {
+	Total    statcount
+	Rooms    map[location]*statcount
+	Days     map[string]*statcount
+	Tracks   map[string]*statcount
+	Speakers map[string]*statcount
+	// Subscribers counts the distinct clients per day and calendar.
+	Subscribers subscribercounts `json:",omitempty"`
+}
+
+func (s *statcount) add(e *event) {
+	s.Events++
+	s.Hours += e.Endtime().Sub(e.Starttime()).Hours()
+}
+
+func count(m map[string]*statcount, key string, e *event) {
+	if m[key] == nil {
+		m[key] = &statcount{}
+	}
+	m[key].add(e)
+}
+
+func schedulestats(c calendar) stats {
+	s := stats{
+		Rooms:    map[location]*statcount{},
+		Days:     map[string]*statcount{},
+		Tracks:   map[string]*statcount{},
+		Speakers: map[string]*statcount{},
+	}
+	for i := range c {
+		e := &c[i]
+		if e.Type == "break" {
+			continue
+		}
+		s.Total.add(e)
+		if s.Rooms[e.Place] == nil {
+			s.Rooms[e.Place] = &statcount{}
+		}
+		s.Rooms[e.Place].add(e)
+		count(s.Days, e.Starttime().Format("2006-01-02"), e)
+		count(s.Tracks, e.Type, e)
+		for _, speaker := range e.Speakers() {
+			count(s.Speakers, speaker, e)
+		}
+	}
+	return s
+}
+
+func handlestats(w http.ResponseWriter, r *http.Request) {
+	s := schedulestats(current().schedule)
+	s.Subscribers = subscriberstats()
+
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+)
+
+// flushchunk is how much of a streamed response is written before it is
+// pushed to the client.
+const flushchunk = 32 << 10
+
+// flushwriter streams a response: it flushes every flushchunk bytes, so
+// nothing piles up in buffers for slow clients, and fails as soon as the
+// client has gone away, so handlers stop producing output nobody reads. The
+// first error sticks, so callers writing many small pieces may check it
+// once in a while instead of after every write.
+type flushwriter struct {
+	ctx     context.Context
+	rc      *http.ResponseController
+	w       http.ResponseWriter
+	pending int
+	err     error
+}
+
+func newflushwriter(w http.ResponseWriter, r *http.Request) *flushwriter {
+	return &flushwriter{ctx: r.Context(), rc: http.NewResponseController(w), w: w}
+}
+
+func (f *flushwriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 && f.err == nil {
+		if f.err = f.ctx.Err(); f.err != nil {
+			break
+		}
+		chunk := p[:min(len(p), flushchunk-f.pending)]
+		n, err := f.w.Write(chunk)
+		written += n
+		f.pending += n
+		p = p[n:]
+		f.err = err
+		if f.err == nil && f.pending >= flushchunk {
+			f.pending = 0
+			if err := f.rc.Flush(); !errors.Is(err, http.ErrNotSupported) {
+				f.err = err
+			}
+		}
+	}
+	return written, f.err
+}
+
+// Err is the first error writing to the client.
+func (f *flushwriter) Err() error {
+	return f.err
+}
+
+// errwriter remembers the first error of w and drops everything after it.
+type errwriter struct {
+	w   io.Writer
+	err error
+}
+
+func (e *errwriter) Write(p []byte) (int, error) {
+	if e.err != nil {
+		return 0, e.err
+	}
+	var n int
+	n, e.err = e.w.Write(p)
+	return n, e.err
+}
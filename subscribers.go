@@ -0,0 +1,166 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"log"
+	"maps"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// subscribercounts maps days to calendar paths to the number of distinct
+// clients that fetched the calendar that day.
+type subscribercounts map[string]map[string]int
+
+// Clients are told apart by a hash of address and user agent, salted with a
+// secret that changes every day and is never stored, so only counts are kept
+// and nobody can follow a client from one day to the next. After a restart
+// the day's clients can't be recognized again, so counts stay at their stored
+// value until more clients than that show up.
+var subscribers = struct {
+	sync.Mutex
+	loaded bool
+	dirty  bool
+	day    string
+	salt   []byte
+	seen   map[string]map[[16]byte]bool
+	counts subscribercounts
+}{}
+
+// subscribedcalendar is the name a fetched calendar is counted under.
+// Personal feeds have secrets in their paths and are counted together.
+func subscribedcalendar(path string) (string, bool) {
+	if !strings.HasSuffix(path, ".ics") {
+		return "", false
+	}
+	for _, personal := range []string{"/feeds/", "/angels/"} {
+		if strings.HasPrefix(path, personal) {
+			return personal, true
+		}
+	}
+	return path, true
+}
+
+// loadsubscribers must be called with subscribers held.
+func loadsubscribers() {
+	if subscribers.loaded {
+		return
+	}
+	subscribers.loaded = true
+	subscribers.counts = subscribercounts{}
+	buf, err := os.ReadFile(conf().Subscribers)
+	if os.IsNotExist(err) {
+		return
+	} else if err == nil {
+		err = json.Unmarshal(buf, &subscribers.counts)
+	}
+	if err != nil {
+		log.Println("loading subscribers:", err)
+	}
+}
+
+// rotatesubscribers must be called with subscribers held.
+func rotatesubscribers(t time.Time) {
+	day := t.In(loc).Format("2006-01-02")
+	if subscribers.day == day {
+		return
+	}
+	subscribers.day = day
+	subscribers.salt = make([]byte, 32)
+	rand.Read(subscribers.salt)
+	subscribers.seen = map[string]map[[16]byte]bool{}
+	oldest := t.In(loc).AddDate(0, 0, -conf().SubscriberDays).Format("2006-01-02")
+	for d := range subscribers.counts {
+		if d <= oldest {
+			delete(subscribers.counts, d)
+			subscribers.dirty = true
+		}
+	}
+}
+
+func countsubscriber(r *http.Request, status int) {
+	if conf().Subscribers == "" || status != http.StatusOK || r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return
+	}
+	name, ok := subscribedcalendar(r.URL.Path)
+	if !ok {
+		return
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	subscribers.Lock()
+	defer subscribers.Unlock()
+	loadsubscribers()
+	rotatesubscribers(now())
+	hash := sha256.New()
+	hash.Write(subscribers.salt)
+	hash.Write([]byte(host + "\x00" + r.UserAgent()))
+	fingerprint := [16]byte(hash.Sum(nil))
+	seen := subscribers.seen[name]
+	if seen == nil {
+		seen = map[[16]byte]bool{}
+		subscribers.seen[name] = seen
+	}
+	if seen[fingerprint] {
+		return
+	}
+	seen[fingerprint] = true
+	day := subscribers.counts[subscribers.day]
+	if day == nil {
+		day = map[string]int{}
+		subscribers.counts[subscribers.day] = day
+	}
+	if len(seen) > day[name] {
+		day[name] = len(seen)
+		subscribers.dirty = true
+	}
+}
+
+func subscriberstats() subscribercounts {
+	if conf().Subscribers == "" {
+		return nil
+	}
+	subscribers.Lock()
+	defer subscribers.Unlock()
+	loadsubscribers()
+	ret := subscribercounts{}
+	for d, c := range subscribers.counts {
+		ret[d] = maps.Clone(c)
+	}
+	return ret
+}
+
+func savesubscribers() error {
+	subscribers.Lock()
+	defer subscribers.Unlock()
+	if !subscribers.dirty || conf().Subscribers == "" {
+		return nil
+	}
+	buf, err := json.Marshal(subscribers.counts)
+	if err != nil {
+		return err
+	}
+	tmp := conf().Subscribers + ".tmp"
+	if err := os.WriteFile(tmp, buf, 0600); err != nil {
+		return err
+	}
+	subscribers.dirty = false
+	return os.Rename(tmp, conf().Subscribers)
+}
+
+func watchsubscribers() {
+	for range time.Tick(time.Minute) {
+		if err := savesubscribers(); err != nil {
+			log.Println("saving subscribers:", err)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const telegramapi = "https://api.telegram.org/bot"
+
+const telegramhelp = `/now - talks running right now
+/next [room] - upcoming talks
+/search <terms> - search the schedule
+/subscribe - get notified about schedule changes
+/unsubscribe - stop notifications`
+
+type telegrambot struct {
+	token       string
+	subscribers string
+	client      *http.Client
+
+	mutex sync.Mutex
+	chats map[int64]bool
+}
+
+type telegramupdate struct {
+	UpdateID int64 `json:"update_id"`
+	Message  *struct {
+		Text string `json:"text"`
+		Chat struct {
+			ID int64 `json:"id"`
+		} `json:"chat"`
+	} `json:"message"`
+}
+
+func newtelegrambot(token, subscribers string) (*telegrambot, error) {
+	t := &telegrambot{
+		token:       token,
+		subscribers: subscribers,
+		client:      &http.Client{Timeout: 90 * time.Second},
+		chats:       map[int64]bool{},
+	}
+	if subscribers == "" {
+		return t, nil
+	}
+	buf, err := os.ReadFile(subscribers)
+	if os.IsNotExist(err) {
+		return t, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var chats []int64
+	if err := json.Unmarshal(buf, &chats); err != nil {
+		return nil, err
+	}
+	for _, c := range chats {
+		t.chats[c] = true
+	}
+	return t, nil
+}
+
+func (t *telegrambot) call(method string, params any, out any) error {
+	buf, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	resp, err := t.client.Post(telegramapi+t.token+"/"+method, "application/json", bytes.NewReader(buf))
+	var uerr *url.Error
+	if errors.As(err, &uerr) {
+		// the URL carries the token, which must not end up in the logs
+		return fmt.Errorf("telegram: %s: %w", method, uerr.Err)
+	} else if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var result struct {
+		OK          bool            `json:"ok"`
+		Description string          `json:"description"`
+		Result      json.RawMessage `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+	if !result.OK {
+		return fmt.Errorf("telegram: %s: %s", method, result.Description)
+	}
+	if out != nil {
+		return json.Unmarshal(result.Result, out)
+	}
+	return nil
+}
+
+func (t *telegrambot) send(chat int64, text string) error {
+	return t.call("sendMessage", map[string]any{"chat_id": chat, "text": text}, nil)
+}
+
+// savesubscribers must be called with mutex held.
+func (t *telegrambot) savesubscribers() error {
+	if t.subscribers == "" {
+		return nil
+	}
+	chats := []int64{}
+	for c := range t.chats {
+		chats = append(chats, c)
+	}
+	sort.Slice(chats, func(i, j int) bool { return chats[i] < chats[j] })
+	buf, err := json.Marshal(chats)
+	if err != nil {
+		return err
+	}
+	tmp := t.subscribers + ".tmp"
+	if err := os.WriteFile(tmp, buf, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, t.subscribers)
+}
+
+func (t *telegrambot) subscribe(chat int64, on bool) string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if on {
+		t.chats[chat] = true
+	} else {
+		delete(t.chats, chat)
+	}
+	if err := t.savesubscribers(); err != nil {
+		log.Println("telegram:", err)
+	}
+	if on {
+		return "Subscribed to schedule changes."
+	}
+	return "Unsubscribed."
+}
+
+func (t *telegrambot) notify(msgs []string) error {
+	t.mutex.Lock()
+	var chats []int64
+	for c := range t.chats {
+		chats = append(chats, c)
+	}
+	t.mutex.Unlock()
+
+	var errs []error
+	for _, c := range chats {
+		errs = append(errs, t.send(c, strings.Join(msgs, "\n")))
+	}
+	return errors.Join(errs...)
+}
+
+func telegramlist(c calendar) string {
+	if len(c) == 0 {
+		return "Nothing found."
+	}
+	var lines []string
+	for i := range c {
+		lines = append(lines, c[i].Announcement())
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (t *telegrambot) answer(chat int64, text string) string {
+	cmd, arg, _ := strings.Cut(strings.TrimSpace(text), " ")
+	cmd, _, _ = strings.Cut(cmd, "@")
+	arg = strings.TrimSpace(arg)
+	switch cmd {
+	case "/subscribe":
+		return t.subscribe(chat, true)
+	case "/unsubscribe":
+		return t.subscribe(chat, false)
+	}
+
+	snap := current()
+	switch cmd {
+	case "/now":
+		var running calendar
+		for _, r := range nownext(snap.schedule, now(), loc) {
+			for _, e := range r.Now {
+				running = append(running, e.event)
+			}
+		}
+		return telegramlist(running)
+	case "/next":
+		var next calendar
+		for _, r := range nownext(snap.schedule, now(), loc) {
+			if r.Next != nil && (arg == "" || strings.EqualFold(r.Room.String(), arg)) {
+				next = append(next, r.Next.event)
+			}
+		}
+		return telegramlist(next)
+	case "/search":
+		results := snap.index.Search(arg)
+		if len(results) > 10 {
+			results = results[:10]
+		}
+		return telegramlist(results)
+	}
+	return telegramhelp
+}
+
+func (t *telegrambot) run() {
+	var offset int64
+	for {
+		var updates []telegramupdate
+		err := t.call("getUpdates", map[string]any{"offset": offset, "timeout": 60, "allowed_updates": []string{"message"}}, &updates)
+		if err != nil {
+			log.Println(err)
+			time.Sleep(10 * time.Second)
+			continue
+		}
+		for _, u := range updates {
+			offset = u.UpdateID + 1
+			if u.Message == nil || !strings.HasPrefix(u.Message.Text, "/") {
+				continue
+			}
+			if err := t.send(u.Message.Chat.ID, t.answer(u.Message.Chat.ID, u.Message.Text)); err != nil {
+				log.Println(err)
+			}
+		}
+	}
+}
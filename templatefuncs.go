@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// templatefuncs are the helpers for custom templates, so that pages can be
+// adapted to an event without Go changes.
+func templatefuncs(lang string) template.FuncMap {
+	return template.FuncMap{
+		"FormatTime": func(t time.Time, layout string) string { return formattime(lang, t, layout) },
+		"Duration":   humanduration,
+		"Slug":       slugify,
+		"Markdown":   markdown,
+		"FeedPath":   feedpath,
+		"FeedURL":    feedurl,
+		"Webcal":     webcal,
+	}
+}
+
+// formattime is time.Format with weekday and month names from the catalog.
+// Names are written outside of Format, as translations like "Januar" would
+// otherwise be taken for layout elements.
+func formattime(lang string, t time.Time, layout string) string {
+	names := []string{"Monday", "Mon", "January", "Jan"}
+	var b strings.Builder
+	for layout != "" {
+		i, name := len(layout), ""
+		for _, n := range names {
+			if j := strings.Index(layout, n); j >= 0 && (j < i || j == i && len(n) > len(name)) {
+				i, name = j, n
+			}
+		}
+		b.WriteString(t.Format(layout[:i]))
+		switch name {
+		case "Monday":
+			b.WriteString(translate(lang, "day."+t.Weekday().String()))
+		case "Mon":
+			b.WriteString(translate(lang, "day."+t.Weekday().String()+".short"))
+		case "January":
+			b.WriteString(translate(lang, "month."+t.Month().String()))
+		case "Jan":
+			b.WriteString(translate(lang, "month."+t.Month().String()+".short"))
+		}
+		layout = layout[i+len(name):]
+	}
+	return b.String()
+}
+
+// feedpath is the path of the calendar of a room, track, speaker or day
+// (given as 2006-01-02).
+func feedpath(kind, name string) (string, error) {
+	switch kind {
+	case "room":
+		return roompath(location(name)), nil
+	case "track":
+		return "/tracks/" + url.PathEscape(name) + ".ics", nil
+	case "speaker":
+		return "/speakers/" + url.PathEscape(name) + ".ics", nil
+	case "day":
+		return "/all.ics?day=" + url.QueryEscape(name), nil
+	case "all":
+		return "/all.ics", nil
+	}
+	return "", fmt.Errorf("unknown calendar kind %q", kind)
+}
+
+// feedurl makes path absolute with BaseURL, if that is set.
+func feedurl(path string) string {
+	if conf().BaseURL == "" {
+		return path
+	}
+	return strings.TrimSuffix(conf().BaseURL, "/") + path
+}
+
+// webcal is the subscription link for path. Without BaseURL there is no host
+// to put in it, so it stays a plain link.
+func webcal(path string) template.URL {
+	u, err := url.Parse(feedurl(path))
+	if err != nil || u.Host == "" {
+		return template.URL(path)
+	}
+	u.Scheme = "webcal"
+	return template.URL(u.String())
+}
+
+var (
+	markdownlink   = regexp.MustCompile(`\[([^\]]+)\]\(((?:https?://|mailto:)[^)\s]+)\)`)
+	markdownstrong = regexp.MustCompile(`\*\*([^*]+)\*\*|__([^_]+)__`)
+	markdownem     = regexp.MustCompile(`\*([^*\s][^*]*)\*|\b_([^_\s][^_]*)_\b`)
+	markdownhead   = regexp.MustCompile(`^(#{1,6})\s+(.*)$`)
+	markdownitem   = regexp.MustCompile(`^\s*[-*+]\s+(.*)$`)
+)
+
+func markdowninline(s string) string {
+	parts := strings.Split(s, "`")
+	for i, p := range parts {
+		p = template.HTMLEscapeString(p)
+		if i%2 == 1 && i < len(parts)-1 {
+			parts[i] = "<code>" + p + "</code>"
+			continue
+		}
+		p = markdownlink.ReplaceAllString(p, `<a href="$2">$1</a>`)
+		p = markdownstrong.ReplaceAllString(p, "<strong>$1$2</strong>")
+		p = markdownem.ReplaceAllString(p, "<em>$1$2</em>")
+		if i%2 == 1 {
+			p = "`" + p
+		}
+		parts[i] = p
+	}
+	return strings.Join(parts, "")
+}
+
+// markdown renders the Markdown commonly found in talk descriptions:
+// paragraphs, headings, lists, emphasis, code and links. Everything else is
+// escaped, including raw HTML, so the result is safe to embed.
+func markdown(s string) template.HTML {
+	var b strings.Builder
+	var paragraph []string
+	list := false
+	flush := func() {
+		if len(paragraph) > 0 {
+			b.WriteString("<p>" + strings.Join(paragraph, "<br/>\n") + "</p>\n")
+			paragraph = nil
+		}
+		if list {
+			b.WriteString("</ul>\n")
+			list = false
+		}
+	}
+	for _, line := range strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n") {
+		switch {
+		case strings.TrimSpace(line) == "":
+			flush()
+		case markdownhead.MatchString(line):
+			flush()
+			m := markdownhead.FindStringSubmatch(line)
+			n := len(m[1])
+			fmt.Fprintf(&b, "<h%d>%s</h%d>\n", n, markdowninline(m[2]), n)
+		case markdownitem.MatchString(line):
+			if len(paragraph) > 0 {
+				flush()
+			}
+			if !list {
+				b.WriteString("<ul>\n")
+				list = true
+			}
+			b.WriteString("<li>" + markdowninline(markdownitem.FindStringSubmatch(line)[1]) + "</li>\n")
+		default:
+			if list {
+				flush()
+			}
+			paragraph = append(paragraph, markdowninline(strings.TrimSpace(line)))
+		}
+	}
+	flush()
+	return template.HTML(b.String())
+}
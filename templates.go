@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"html/template"
+	"io"
+	"io/fs"
+	"log"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+//go:embed templates/*.html
+var embeddedtemplates embed.FS
+
+// templates holds one parsed set per language, so that requests don't need
+// to clone and rebind them. Sets for other time zones than the schedule's
+// are parsed on first use and kept in zonedtemplates, as html/template
+// can't clone a set once it has been executed.
+var (
+	templates      = mustparsetemplates()
+	zonedtemplates = map[string]*template.Template{}
+	templatesmutex = sync.RWMutex{}
+)
+
+func newtemplate(lang string, tz *time.Location) *template.Template {
+	return template.New("").Funcs(templatefuncs(lang)).Funcs(template.FuncMap{
+		"T":     func(key string) string { return translate(lang, key) },
+		"Lang":  func() string { return lang },
+		"Local": func(t time.Time) time.Time { return t.In(tz) },
+		"Feeds": func() bool { return conf().Feeds != "" },
+		"Stale": func() string { return stalebanner(lang) },
+	})
+}
+
+func parsetemplateset(lang string, tz *time.Location) (*template.Template, error) {
+	t, err := newtemplate(lang, tz).ParseFS(embeddedtemplates, "templates/*.html")
+	if err != nil {
+		return nil, err
+	}
+	if conf().Templates == "" {
+		return t, nil
+	}
+	if matches, _ := fs.Glob(os.DirFS(conf().Templates), "*.html"); len(matches) == 0 {
+		return t, nil
+	}
+	return t.ParseFS(os.DirFS(conf().Templates), "*.html")
+}
+
+func parsetemplates() (map[string]*template.Template, error) {
+	ret := map[string]*template.Template{}
+	for _, lang := range append(slices.Collect(maps.Keys(catalogs)), conf().Language) {
+		t, err := parsetemplateset(lang, loc)
+		if err != nil {
+			return nil, err
+		}
+		ret[lang] = t
+	}
+	return ret, nil
+}
+
+func mustparsetemplates() map[string]*template.Template {
+	t, err := parsetemplates()
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func loadtemplates() error {
+	t, err := parsetemplates()
+	if err != nil {
+		return err
+	}
+	templatesmutex.Lock()
+	templates = t
+	zonedtemplates = map[string]*template.Template{}
+	templatesmutex.Unlock()
+	resetpagecache()
+	return nil
+}
+
+func templatesmodtime() (ret time.Time) {
+	matches, _ := fs.Glob(os.DirFS(conf().Templates), "*.html")
+	for _, m := range matches {
+		if fi, err := fs.Stat(os.DirFS(conf().Templates), m); err == nil && fi.ModTime().After(ret) {
+			ret = fi.ModTime()
+		}
+	}
+	return
+}
+
+func watchtemplates(interval time.Duration) {
+	if conf().Templates == "" {
+		return
+	}
+	last := templatesmodtime()
+	for range time.Tick(interval) {
+		if mod := templatesmodtime(); mod.After(last) {
+			last = mod
+			if err := loadtemplates(); err != nil {
+				log.Println("reloading templates:", err)
+			}
+		}
+	}
+}
+
+func render(w http.ResponseWriter, r *http.Request, name string, data any) error {
+	tz, err := requestlocation(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return err
+	}
+
+	lang := requestlanguage(r)
+	var buf bytes.Buffer
+	err = executetemplate(&buf, lang, tz, name, data)
+	writepage(w, r, name, lang, buf.Bytes(), err)
+	return err
+}
+
+func executetemplate(w io.Writer, lang string, tz *time.Location, name string, data any) error {
+	templatesmutex.RLock()
+	t, ok := templates[lang]
+	if !ok {
+		lang = conf().Language
+		t = templates[lang]
+	}
+	base, key := t, lang+" "+tz.String()
+	if tz != loc {
+		t = zonedtemplates[key]
+	}
+	templatesmutex.RUnlock()
+
+	if t == nil {
+		var err error
+		if t, err = parsetemplateset(lang, tz); err != nil {
+			return err
+		}
+		templatesmutex.Lock()
+		// a reload in the meantime makes this set outdated already
+		if templates[lang] == base {
+			zonedtemplates[key] = t
+		}
+		templatesmutex.Unlock()
+	}
+	return t.ExecuteTemplate(w, name, data)
+}
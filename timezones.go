@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+const (
+	icaltimesutc      = "utc"
+	icaltimesfloating = "floating"
+	icaltimestzid     = "tzid"
+)
+
+func icallocaltime(t time.Time) string {
+	return t.Format("20060102T150405")
+}
+
+// icaltime writes a DATE-TIME property as UTC, floating local time or local
+// time with a TZID reference, depending on times.
+func icaltime(w io.Writer, key string, t time.Time, tz *time.Location, times string) {
+	switch times {
+	case icaltimesfloating:
+		icalformatline(w, key, icallocaltime(t.In(tz)))
+	case icaltimestzid:
+		fmt.Fprintf(w, "%s;TZID=%s:%s\r\n", key, tz, icallocaltime(t.In(tz)))
+	default:
+		icalformatline(w, key, icaldatetime(t))
+	}
+}
+
+func icaloffset(seconds int) string {
+	sign := '+'
+	if seconds < 0 {
+		sign, seconds = '-', -seconds
+	}
+	return fmt.Sprintf("%c%02d%02d", sign, seconds/3600, seconds/60%60)
+}
+
+// VTIMEZONE describes tz between from and to with one observance per zone
+// period, taken from the Go time zone database.
+func VTIMEZONE(w io.Writer, tz *time.Location, from, to time.Time) {
+	icalformatline(w, "BEGIN", "VTIMEZONE")
+	icalformatline(w, "TZID", tz.String())
+	for t := from.In(tz); ; {
+		start, end := t.ZoneBounds()
+		name, offset := t.Zone()
+		prev := offset
+		if start.IsZero() {
+			start = time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC)
+		} else {
+			_, prev = start.Add(-time.Second).Zone()
+		}
+
+		kind := "STANDARD"
+		if t.IsDST() {
+			kind = "DAYLIGHT"
+		}
+		icalformatline(w, "BEGIN", kind)
+		icalformatline(w, "DTSTART", icallocaltime(start.UTC().Add(time.Duration(prev)*time.Second)))
+		icalformatline(w, "TZOFFSETFROM", icaloffset(prev))
+		icalformatline(w, "TZOFFSETTO", icaloffset(offset))
+		icalformatline(w, "TZNAME", name)
+		icalformatline(w, "END", kind)
+
+		if end.IsZero() || end.After(to) {
+			break
+		}
+		t = end
+	}
+	icalformatline(w, "END", "VTIMEZONE")
+}
+
+func (c calendar) VTIMEZONEs(w io.Writer) {
+	type span struct{ from, to time.Time }
+	zones := map[*time.Location]*span{}
+	for i := range c {
+		e := &c[i]
+		tz := e.Zone()
+		s := zones[tz]
+		if s == nil {
+			s = &span{e.Starttime(), e.Endtime()}
+			zones[tz] = s
+		}
+		if e.Starttime().Before(s.from) {
+			s.from = e.Starttime()
+		}
+		if e.Endtime().After(s.to) {
+			s.to = e.Endtime()
+		}
+	}
+
+	var tzs []*time.Location
+	for tz := range zones {
+		tzs = append(tzs, tz)
+	}
+	sort.Slice(tzs, func(i, j int) bool { return tzs[i].String() < tzs[j].String() })
+	for _, tz := range tzs {
+		VTIMEZONE(w, tz, zones[tz].from, zones[tz].to)
+	}
+}
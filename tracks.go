@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+type trackmetadata struct {
+	ID          string
+	Name        string
+	Description string
+	Color       string
+	Icon        string
+}
+
+func (t trackmetadata) IconPath() string {
+	return imagepath(t.Icon)
+}
+
+type track struct {
+	trackmetadata
+	Events calendar
+}
+
+func (e *event) Track() trackmetadata {
+	t := conf().Tracks[e.Type]
+	t.ID = e.Type
+	if t.Name == "" {
+		t.Name = e.Type
+	}
+	return t
+}
+
+func tracks(c calendar) (ret []track) {
+	index := map[string]int{}
+	for _, e := range c {
+		if e.Type == "" || e.Type == "break" {
+			continue
+		}
+		i, ok := index[e.Type]
+		if !ok {
+			i = len(ret)
+			index[e.Type] = i
+			ret = append(ret, track{trackmetadata: e.Track()})
+		}
+		ret[i].Events = append(ret[i].Events, e)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return strings.ToLower(ret[i].Name) < strings.ToLower(ret[j].Name)
+	})
+	for _, t := range ret {
+		sort.Slice(t.Events, func(i, j int) bool {
+			return t.Events[i].Starttime().Before(t.Events[j].Starttime())
+		})
+	}
+	return
+}
+
+func (c calendar) Track(id string) (ret calendar) {
+	for _, e := range c {
+		if e.Type == id {
+			ret = append(ret, e)
+		}
+	}
+	return
+}
+
+func handletracks(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/tracks")
+	if id == "" || id == "/" {
+		rendercached(w, r, "tracks.html", 0, func(snap *snapshot) any {
+			return tracks(snap.schedule)
+		})
+		return
+	}
+
+	id = strings.TrimSuffix(id[1:], ".ics")
+	c := current().schedule.Track(id)
+	if len(c) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	if !strings.HasSuffix(r.URL.Path, ".ics") {
+		render(w, r, "tracks.html", []track{{c[0].Track(), c}})
+		return
+	}
+	w.Header().Add("Content-Type", "text/calendar")
+	w.Write(c.ICal())
+}
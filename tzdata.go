@@ -0,0 +1,8 @@
+//go:build !notzdata
+
+package main
+
+// The embedded time zone database keeps the times right on minimal container
+// images without /usr/share/zoneinfo. Build with -tags notzdata to rely on
+// the system's copy instead.
+import _ "time/tzdata"
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// publicupstream drops the events of private rooms from a raw payload and
+// leaves everything else as it came from upstream.
+func publicupstream(buf []byte) ([]byte, error) {
+	if len(conf().Private) == 0 {
+		return buf, nil
+	}
+	var raw []json.RawMessage
+	if err := json.Unmarshal(buf, &raw); err != nil {
+		return nil, err
+	}
+	var public [][]byte
+	for _, m := range raw {
+		var e struct{ Place location }
+		json.Unmarshal(m, &e)
+		if _, private := conf().Private[e.Place]; !private {
+			public = append(public, m)
+		}
+	}
+	return append(append([]byte{'['}, bytes.Join(public, []byte(", "))...), ']'), nil
+}
+
+// handleupstream mirrors the last fetched payload of a source, the first one
+// unless ?source= names another, so that tools on the congress network don't
+// all have to hit the origin.
+func handleupstream(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("source")
+	var upstream []byte
+	var changed time.Time
+	found := false
+	sourcesmutex.Lock()
+	for _, s := range sourcestates {
+		if !found && (name == "" || s.Name == name) {
+			upstream, changed, found = s.upstream, s.changed, true
+		}
+	}
+	sourcesmutex.Unlock()
+	if !found {
+		http.NotFound(w, r)
+		return
+	}
+	if upstream == nil {
+		http.Error(w, "nothing fetched yet", http.StatusServiceUnavailable)
+		return
+	}
+	buf, err := publicupstream(upstream)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	sum := sha256.Sum256(buf)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:8])+`"`)
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(nominalinterval(time.Now()).Seconds())))
+	http.ServeContent(w, r, "", changed, bytes.NewReader(buf))
+}
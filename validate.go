@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+type icalproblem struct {
+	line int
+	uid  string
+	msg  string
+}
+
+func (p icalproblem) String() string {
+	if p.uid != "" {
+		return fmt.Sprintf("line %d (UID %s): %s", p.line, p.uid, p.msg)
+	}
+	return fmt.Sprintf("line %d: %s", p.line, p.msg)
+}
+
+type icalcomponent struct {
+	name  string
+	line  int
+	props map[string][]string
+}
+
+var (
+	icalname            = regexp.MustCompile(`^[A-Za-z0-9-]+$`)
+	icaldurationpattern = regexp.MustCompile(`^[+-]?P(\d+W|(\d+D)?(T(\d+H)?(\d+M)?(\d+S)?)?)$`)
+)
+
+func splitcontentline(line string) (name, params, value string, ok bool) {
+	inquote := false
+	for i, r := range line {
+		switch {
+		case r == '"':
+			inquote = !inquote
+		case r == ':' && !inquote:
+			name, params, _ = strings.Cut(line[:i], ";")
+			return strings.ToUpper(name), params, line[i+1:], true
+		}
+	}
+	return "", "", "", false
+}
+
+func parseicaltime(v string) (time.Time, error) {
+	for _, layout := range []string{"20060102T150405Z", "20060102T150405", "20060102"} {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date-time %q", v)
+}
+
+func (c *icalcomponent) check(add func(string)) {
+	once := func(names ...string) {
+		for _, n := range names {
+			if len(c.props[n]) != 1 {
+				add(fmt.Sprintf("%s must contain exactly one %s, found %d", c.name, n, len(c.props[n])))
+			}
+		}
+	}
+	switch c.name {
+	case "VCALENDAR":
+		once("PRODID", "VERSION")
+		if v := c.props["VERSION"]; len(v) == 1 && v[0] != "2.0" {
+			add("unsupported VERSION " + v[0])
+		}
+	case "VEVENT":
+		once("UID", "DTSTAMP", "DTSTART")
+		if len(c.props["DTEND"]) > 0 && len(c.props["DURATION"]) > 0 {
+			add("VEVENT must not contain both DTEND and DURATION")
+		}
+		if s, e := c.props["DTSTART"], c.props["DTEND"]; len(s) == 1 && len(e) == 1 {
+			start, err1 := parseicaltime(s[0])
+			end, err2 := parseicaltime(e[0])
+			if err1 == nil && err2 == nil && end.Before(start) {
+				add("DTEND before DTSTART")
+			}
+		}
+	case "VFREEBUSY":
+		once("UID", "DTSTAMP")
+	}
+}
+
+func validateical(data []byte) (ret []icalproblem) {
+	if !bytes.HasSuffix(data, CRLF) {
+		ret = append(ret, icalproblem{line: 0, msg: "calendar does not end with CRLF"})
+	}
+
+	var logical []string
+	var linenos []int
+	for i, line := range strings.Split(strings.TrimSuffix(string(data), "\r\n"), "\r\n") {
+		if len(line) > 75 {
+			ret = append(ret, icalproblem{line: i + 1, msg: fmt.Sprintf("line longer than 75 octets (%d)", len(line))})
+		}
+		if strings.ContainsAny(line, "\r\n") {
+			ret = append(ret, icalproblem{line: i + 1, msg: "bare CR or LF"})
+		}
+		if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+			if len(logical) == 0 {
+				ret = append(ret, icalproblem{line: i + 1, msg: "continuation line without content line"})
+				continue
+			}
+			logical[len(logical)-1] += line[1:]
+			continue
+		}
+		logical = append(logical, line)
+		linenos = append(linenos, i+1)
+	}
+
+	var stack []*icalcomponent
+	uids := map[string]int{}
+	for i, line := range logical {
+		lineno := linenos[i]
+		uid := ""
+		if len(stack) > 0 {
+			if u := stack[len(stack)-1].props["UID"]; len(u) > 0 {
+				uid = u[0]
+			}
+		}
+		add := func(msg string) {
+			ret = append(ret, icalproblem{lineno, uid, msg})
+		}
+
+		name, _, value, ok := splitcontentline(line)
+		if !ok || !icalname.MatchString(name) {
+			add(fmt.Sprintf("malformed content line %q", line))
+			continue
+		}
+
+		switch name {
+		case "BEGIN":
+			if len(stack) == 0 && value != "VCALENDAR" {
+				add("calendar must start with BEGIN:VCALENDAR")
+			}
+			stack = append(stack, &icalcomponent{name: value, line: lineno, props: map[string][]string{}})
+			continue
+		case "END":
+			if len(stack) == 0 || stack[len(stack)-1].name != value {
+				add("unbalanced END:" + value)
+				continue
+			}
+			stack[len(stack)-1].check(add)
+			stack = stack[:len(stack)-1]
+			continue
+		}
+
+		if len(stack) == 0 {
+			add("property " + name + " outside of a component")
+			continue
+		}
+		c := stack[len(stack)-1]
+		c.props[name] = append(c.props[name], value)
+
+		switch name {
+		case "DTSTART", "DTEND", "DTSTAMP":
+			if _, err := parseicaltime(value); err != nil {
+				add(name + ": " + err.Error())
+			}
+		case "DURATION":
+			if !icaldurationpattern.MatchString(value) {
+				add(fmt.Sprintf("DURATION: invalid duration %q", value))
+			}
+		case "UID":
+			if c.name == "VEVENT" {
+				if prev, ok := uids[value]; ok {
+					add(fmt.Sprintf("duplicate UID, first seen in line %d", prev))
+				}
+				uids[value] = lineno
+			}
+		}
+	}
+	for _, c := range stack {
+		ret = append(ret, icalproblem{line: c.line, msg: "unterminated " + c.name})
+	}
+	return
+}
+
+func validatecmd(args []string) int {
+	fs, configfile := configflags("validate")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gpnsched validate [-config file] [source]")
+		fs.PrintDefaults()
+	}
+	parseflags(fs, configfile, args)
+	events, err := loadschedule(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	events, hidden := prepareschedule(events)
+	rendered := rendercalendars(events, defaulticaloptions())
+	for room, c := range hidden {
+		rendered[room] = c.ICalWith(defaulticaloptions())
+		events = append(events, c...)
+	}
+	var rooms []location
+	for room := range rendered {
+		rooms = append(rooms, room)
+	}
+	sort.Slice(rooms, func(i, j int) bool { return rooms[i] < rooms[j] })
+
+	problems := 0
+	for _, room := range rooms {
+		for _, p := range validateical(rendered[room]) {
+			if e := events.Event(p.uid); e != nil {
+				fmt.Printf("%s: %s %s: %s\n", room, e.Start, e.Titlestring(), p)
+			} else {
+				fmt.Printf("%s: %s\n", room, p)
+			}
+			problems++
+		}
+	}
+	if problems > 0 {
+		fmt.Printf("%d problems in %d events\n", problems, len(events))
+		return 1
+	}
+	return 0
+}
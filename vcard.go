@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+func (s *speaker) Affiliation() string {
+	for _, e := range s.Talks {
+		if e.Affiliation != "" && e.Affiliation != s.Name {
+			return e.Affiliation
+		}
+	}
+	return ""
+}
+
+func (s *speaker) VCARD(w io.Writer, base string) {
+	icalformatline(w, "BEGIN", "VCARD")
+	icalformatline(w, "VERSION", "3.0")
+	icalformatline(w, "FN", s.Name)
+	fmt.Fprintf(w, "N:%s;;;;\r\n", icalescape(s.Name))
+	if org := s.Affiliation(); org != "" {
+		icalformatline(w, "ORG", org)
+	}
+	icalformatline(w, "URL", base+"/speakers/"+url.PathEscape(s.Name))
+	icalformatline(w, "END", "VCARD")
+}
+
+func handlevcards(w http.ResponseWriter, r *http.Request) {
+	s := speakers(current().schedule)
+
+	var buf bytes.Buffer
+	bw := NewBreakLongLineWriter(&buf, 75)
+	for i := range s {
+		s[i].VCARD(bw, baseurl(r))
+	}
+	w.Header().Add("Content-Type", "text/vcard")
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"sort"
+	"time"
+)
+
+// Set with -ldflags "-X main.buildversion=… -X main.buildcommit=… -X main.builddate=…".
+var buildversion, buildcommit, builddate string
+
+type versioninfo struct {
+	Version  string
+	Commit   string
+	Date     string
+	Modified bool `json:",omitempty"`
+	Go       string
+	Host     string
+	Started  string
+	Schedule string
+	Config   configsummary
+}
+
+// configsummary is the part of the config that is safe to show publicly.
+type configsummary struct {
+	Listen       []string
+	Sources      []string
+	Language     string
+	Interval     float64
+	LiveInterval float64
+	IdleInterval float64
+	Strict       bool
+	Times        string
+	Private      int
+	Enabled      []string
+}
+
+func newconfigsummary() configsummary {
+	s := configsummary{
+		Language:     conf().Language,
+		Interval:     conf().Interval,
+		LiveInterval: conf().LiveInterval,
+		IdleInterval: conf().IdleInterval,
+		Strict:       conf().Strict,
+		Times:        conf().Times,
+		Private:      len(conf().Private),
+		Enabled:      []string{},
+	}
+	for _, l := range listeners() {
+		s.Listen = append(s.Listen, l.Addr)
+	}
+	for _, src := range configuredsources() {
+		s.Sources = append(s.Sources, src.Name)
+	}
+	for name, on := range map[string]bool{
+		"admin":      conf().Admin.Password != "" || conf().Admin.ClientCA != "",
+		"caldavpush": conf().CalDAVPush.URL != "",
+		"feeds":      conf().Feeds != "",
+		"google":     conf().Google.Calendar != "",
+		"overrides":  conf().Overrides != "",
+		"protect":    conf().Protect.Password != "" || conf().Protect.Token != "",
+		"sign":       conf().Sign.Key != "",
+		"telegram":   conf().Telegram.Token != "",
+		"tls":        slices.ContainsFunc(listeners(), func(l listener) bool { return l.TLS.Cert != "" }),
+		"xmpp":       conf().XMPP.JID != "",
+	} {
+		if on {
+			s.Enabled = append(s.Enabled, name)
+		}
+	}
+	sort.Strings(s.Enabled)
+	return s
+}
+
+func newversioninfo() versioninfo {
+	v := versioninfo{
+		Version:  buildversion,
+		Commit:   buildcommit,
+		Date:     builddate,
+		Go:       runtime.Version(),
+		Started:  started.Format(time.RFC3339),
+		Schedule: current().version,
+		Config:   newconfigsummary(),
+	}
+	v.Host, _ = os.Hostname()
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if v.Version == "" && info.Main.Version != "(devel)" {
+			v.Version = info.Main.Version
+		}
+		for _, s := range info.Settings {
+			switch {
+			case s.Key == "vcs.revision" && v.Commit == "":
+				v.Commit = s.Value
+			case s.Key == "vcs.time" && v.Date == "":
+				v.Date = s.Value
+			case s.Key == "vcs.modified":
+				v.Modified = s.Value == "true"
+			}
+		}
+	}
+	if v.Version == "" {
+		v.Version = "devel"
+	}
+	return v
+}
+
+func handleversion(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(newversioninfo())
+}
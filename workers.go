@@ -0,0 +1,33 @@
+package main
+
+import (
+	"runtime"
+	"sync"
+)
+
+// renderworkers is how many calendars are rendered at once during a sync.
+func renderworkers() int {
+	if conf().RenderWorkers > 0 {
+		return conf().RenderWorkers
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
+// parallel calls fn for 0 to n-1 on at most workers goroutines and returns
+// when all calls have.
+func parallel(n, workers int, fn func(i int)) {
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for range min(n, max(workers, 1)) {
+		wg.Go(func() {
+			for i := range jobs {
+				fn(i)
+			}
+		})
+	}
+	for i := range n {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+}
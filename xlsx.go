@@ -0,0 +1,184 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	xlsxcontenttypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+<Default Extension="xml" ContentType="application/xml"/>
+<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>
+<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>
+%s</Types>`
+	xlsxrels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>
+</Relationships>`
+	// cell styles: 0 default, 1 bold header, 2 date, 3 time
+	xlsxstyles = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+<numFmts count="2"><numFmt numFmtId="164" formatCode="yyyy-mm-dd"/><numFmt numFmtId="165" formatCode="hh:mm"/></numFmts>
+<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>
+<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>
+<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>
+<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>
+<cellXfs count="4">
+<xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>
+<xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/>
+<xf numFmtId="164" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>
+<xf numFmtId="165" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>
+</cellXfs>
+</styleSheet>`
+)
+
+var (
+	xlsxepoch     = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+	xlsxsheetname = strings.NewReplacer("[", "(", "]", ")", ":", "-", "*", "", "?", "", "/", "-", `\`, "-")
+)
+
+type xlsxsheet struct {
+	Name   string
+	Events calendar
+}
+
+func xlsxserial(t time.Time) float64 {
+	wall := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.UTC)
+	return wall.Sub(xlsxepoch).Hours() / 24
+}
+
+func xlsxcell(w io.Writer, col, row int, style int, value any) {
+	ref := fmt.Sprintf("%c%d", 'A'+col, row)
+	switch v := value.(type) {
+	case float64:
+		fmt.Fprintf(w, `<c r="%s" s="%d"><v>%g</v></c>`, ref, style, v)
+	case string:
+		fmt.Fprintf(w, `<c r="%s" s="%d" t="inlineStr"><is><t xml:space="preserve">`, ref, style)
+		xml.EscapeText(w, []byte(v))
+		io.WriteString(w, `</t></is></c>`)
+	}
+}
+
+func (s xlsxsheet) write(w io.Writer) {
+	io.WriteString(w, `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+<sheetViews><sheetView workbookViewId="0"><pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/></sheetView></sheetViews>
+<cols><col min="1" max="1" width="12" customWidth="1"/><col min="2" max="3" width="8" customWidth="1"/><col min="4" max="4" width="16" customWidth="1"/><col min="5" max="5" width="50" customWidth="1"/><col min="6" max="6" width="30" customWidth="1"/><col min="7" max="7" width="12" customWidth="1"/></cols>
+<sheetData>`)
+	io.WriteString(w, `<row r="1">`)
+	for i, h := range []string{"Date", "Start", "End", "Room", "Title", "Speaker", "Type"} {
+		xlsxcell(w, i, 1, 1, h)
+	}
+	io.WriteString(w, `</row>`)
+	for i := range s.Events {
+		e := &s.Events[i]
+		row := i + 2
+		start, end := e.Starttime().In(loc), e.Endtime().In(loc)
+		fmt.Fprintf(w, `<row r="%d">`, row)
+		xlsxcell(w, 0, row, 2, float64(int(xlsxserial(start))))
+		xlsxcell(w, 1, row, 3, xlsxserial(start))
+		xlsxcell(w, 2, row, 3, xlsxserial(end))
+		xlsxcell(w, 3, row, 0, e.Place.DisplayName())
+		xlsxcell(w, 4, row, 0, e.Title)
+		xlsxcell(w, 5, row, 0, e.Speaker)
+		xlsxcell(w, 6, row, 0, e.Type)
+		io.WriteString(w, `</row>`)
+	}
+	io.WriteString(w, `</sheetData></worksheet>`)
+}
+
+func xlsxsheets(c calendar, byroom bool) (ret []xlsxsheet) {
+	sorted := append(calendar(nil), c...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Starttime().Before(sorted[j].Starttime())
+	})
+
+	index := map[string]int{}
+	for _, e := range sorted {
+		key := e.Starttime().In(loc).Format("Mon 2006-01-02")
+		if byroom {
+			key = e.Place.DisplayName()
+		}
+		i, ok := index[key]
+		if !ok {
+			i = len(ret)
+			index[key] = i
+			ret = append(ret, xlsxsheet{Name: key})
+		}
+		ret[i].Events = append(ret[i].Events, e)
+	}
+	if byroom {
+		sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+	}
+	return
+}
+
+func writexlsx(w io.Writer, sheets []xlsxsheet) error {
+	var overrides, entries, rels strings.Builder
+	used := map[string]bool{}
+	for i, s := range sheets {
+		n := i + 1
+		name := []rune(xlsxsheetname.Replace(s.Name))
+		if len(name) > 31 {
+			name = name[:31]
+		}
+		for used[strings.ToLower(string(name))] {
+			name = []rune(fmt.Sprintf("%.28s %d", string(name), n))
+		}
+		used[strings.ToLower(string(name))] = true
+
+		fmt.Fprintf(&overrides, `<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`+"\n", n)
+		fmt.Fprintf(&entries, `<sheet name="%s" sheetId="%d" r:id="rId%d"/>`, xmlescape(string(name)), n, n)
+		fmt.Fprintf(&rels, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet%d.xml"/>`+"\n", n, n)
+	}
+	fmt.Fprintf(&rels, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>`+"\n", len(sheets)+1)
+
+	z := zip.NewWriter(w)
+	parts := [][2]string{
+		{"[Content_Types].xml", fmt.Sprintf(xlsxcontenttypes, overrides.String())},
+		{"_rels/.rels", xlsxrels},
+		{"xl/workbook.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets>` + entries.String() + `</sheets></workbook>`},
+		{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+` + rels.String() + `</Relationships>`},
+		{"xl/styles.xml", xlsxstyles},
+	}
+	for _, p := range parts {
+		f, err := z.Create(p[0])
+		if err != nil {
+			return err
+		}
+		io.WriteString(f, p[1])
+	}
+	for i, s := range sheets {
+		f, err := z.Create(fmt.Sprintf("xl/worksheets/sheet%d.xml", i+1))
+		if err != nil {
+			return err
+		}
+		s.write(f)
+	}
+	return z.Close()
+}
+
+func handlexlsx(w http.ResponseWriter, r *http.Request) {
+	c := current().schedule
+
+	var buf bytes.Buffer
+	if err := writexlsx(&buf, xlsxsheets(c, r.URL.Query().Get("by") == "room")); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+	w.Header().Set("Content-Disposition", `attachment; filename="schedule.xlsx"`)
+	w.Write(buf.Bytes())
+}
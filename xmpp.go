@@ -0,0 +1,258 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	xmppnsclient = "jabber:client"
+	xmppnsstream = "http://etherx.jabber.org/streams"
+	xmppnstls    = "urn:ietf:params:xml:ns:xmpp-tls"
+	xmppnssasl   = "urn:ietf:params:xml:ns:xmpp-sasl"
+	xmppnsbind   = "urn:ietf:params:xml:ns:xmpp-bind"
+	xmppnsmuc    = "http://jabber.org/protocol/muc"
+)
+
+type xmppnotifier struct {
+	jid, password, server, nick string
+	rooms                       []string
+}
+
+type xmppconn struct {
+	conn io.ReadWriteCloser
+	dec  *xml.Decoder
+}
+
+type xmppfeatures struct {
+	StartTLS   *struct{} `xml:"urn:ietf:params:xml:ns:xmpp-tls starttls"`
+	Mechanisms []string  `xml:"urn:ietf:params:xml:ns:xmpp-sasl mechanisms>mechanism"`
+	Bind       *struct{} `xml:"urn:ietf:params:xml:ns:xmpp-bind bind"`
+}
+
+// xmppstanza is the part of an iq or presence stanza needed to tell
+// whether a request went through.
+type xmppstanza struct {
+	From   string `xml:"from,attr"`
+	Type   string `xml:"type,attr"`
+	Status []struct {
+		Code string `xml:"code,attr"`
+	} `xml:"http://jabber.org/protocol/muc#user x>status"`
+	Error *struct {
+		Conditions []struct {
+			XMLName xml.Name
+		} `xml:",any"`
+	} `xml:"error"`
+}
+
+func (s xmppstanza) err(what string) error {
+	var conds []string
+	if s.Error != nil {
+		for _, c := range s.Error.Conditions {
+			if c.XMLName.Local != "text" {
+				conds = append(conds, c.XMLName.Local)
+			}
+		}
+	}
+	return fmt.Errorf("xmpp: %s failed: %s", what, strings.Join(conds, ", "))
+}
+
+func newxmppnotifier(jid, password, server, nick string, rooms []string) *xmppnotifier {
+	if nick == "" {
+		nick = "gpnsched"
+	}
+	return &xmppnotifier{jid: jid, password: password, server: server, nick: nick, rooms: rooms}
+}
+
+// next returns the next top level element of the stream.
+func (c *xmppconn) next() (xml.StartElement, error) {
+	for {
+		t, err := c.dec.Token()
+		if err != nil {
+			return xml.StartElement{}, err
+		}
+		if se, ok := t.(xml.StartElement); ok {
+			return se, nil
+		}
+	}
+}
+
+func (c *xmppconn) open(domain string) (features xmppfeatures, err error) {
+	fmt.Fprintf(c.conn, "<?xml version='1.0'?><stream:stream to='%s' xmlns='%s' xmlns:stream='%s' version='1.0'>", xmlescape(domain), xmppnsclient, xmppnsstream)
+	c.dec = xml.NewDecoder(c.conn)
+	se, err := c.next()
+	if err != nil {
+		return
+	}
+	if se.Name.Space != xmppnsstream || se.Name.Local != "stream" {
+		return features, fmt.Errorf("xmpp: expected stream, got %s", se.Name.Local)
+	}
+	if se, err = c.next(); err != nil {
+		return
+	}
+	if se.Name.Local != "features" {
+		return features, fmt.Errorf("xmpp: expected features, got %s", se.Name.Local)
+	}
+	err = c.dec.DecodeElement(&features, &se)
+	return
+}
+
+func (c *xmppconn) expect(name string) error {
+	se, err := c.next()
+	if err != nil {
+		return err
+	}
+	if err := c.dec.Skip(); err != nil {
+		return err
+	}
+	if se.Name.Local != name {
+		return fmt.Errorf("xmpp: expected %s, got %s", name, se.Name.Local)
+	}
+	return nil
+}
+
+func (c *xmppconn) bind() error {
+	fmt.Fprintf(c.conn, "<iq type='set' id='bind'><bind xmlns='%s'><resource>gpnsched</resource></bind></iq>", xmppnsbind)
+	se, err := c.next()
+	if err != nil {
+		return err
+	}
+	if se.Name.Local != "iq" {
+		return fmt.Errorf("xmpp: expected iq, got %s", se.Name.Local)
+	}
+	var reply xmppstanza
+	if err := c.dec.DecodeElement(&reply, &se); err != nil {
+		return err
+	}
+	if reply.Type != "result" {
+		return reply.err("bind")
+	}
+	return nil
+}
+
+// join enters a multi-user chat room and waits for the room to confirm
+// it with our own presence, as messages sent before that are dropped.
+func (c *xmppconn) join(room, nick string) error {
+	fmt.Fprintf(c.conn, "<presence to='%s/%s'><x xmlns='%s'><history maxstanzas='0'/></x></presence>", xmlescape(room), xmlescape(nick), xmppnsmuc)
+	for {
+		se, err := c.next()
+		if err != nil {
+			return err
+		}
+		if se.Name.Local != "presence" {
+			if err := c.dec.Skip(); err != nil {
+				return err
+			}
+			continue
+		}
+		var p xmppstanza
+		if err := c.dec.DecodeElement(&p, &se); err != nil {
+			return err
+		}
+		if from, _, _ := strings.Cut(p.From, "/"); !strings.EqualFold(from, room) {
+			continue
+		}
+		if p.Type == "error" {
+			return p.err("joining " + room)
+		}
+		for _, s := range p.Status {
+			if s.Code == "110" {
+				return nil
+			}
+		}
+	}
+}
+
+func (x *xmppnotifier) connect() (*xmppconn, error) {
+	user, domain, ok := strings.Cut(x.jid, "@")
+	if !ok {
+		return nil, fmt.Errorf("xmpp: invalid jid %q", x.jid)
+	}
+	server := x.server
+	if server == "" {
+		server = net.JoinHostPort(domain, "5222")
+		if _, srvs, err := net.LookupSRV("xmpp-client", "tcp", domain); err == nil && len(srvs) > 0 {
+			server = net.JoinHostPort(strings.TrimSuffix(srvs[0].Target, "."), fmt.Sprint(srvs[0].Port))
+		}
+	}
+
+	conn, err := net.DialTimeout("tcp", server, 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(time.Minute))
+	c := &xmppconn{conn: conn}
+	fail := func(err error) (*xmppconn, error) {
+		conn.Close()
+		return nil, err
+	}
+
+	features, err := c.open(domain)
+	if err != nil {
+		return fail(err)
+	}
+	if features.StartTLS == nil {
+		return fail(errors.New("xmpp: server does not offer STARTTLS"))
+	}
+	fmt.Fprintf(c.conn, "<starttls xmlns='%s'/>", xmppnstls)
+	if err := c.expect("proceed"); err != nil {
+		return fail(err)
+	}
+	tlsconn := tls.Client(conn, &tls.Config{ServerName: domain})
+	if err := tlsconn.Handshake(); err != nil {
+		return fail(err)
+	}
+	c.conn = tlsconn
+
+	if features, err = c.open(domain); err != nil {
+		return fail(err)
+	}
+	plain := false
+	for _, m := range features.Mechanisms {
+		plain = plain || m == "PLAIN"
+	}
+	if !plain {
+		return fail(errors.New("xmpp: server does not offer SASL PLAIN"))
+	}
+	fmt.Fprintf(c.conn, "<auth xmlns='%s' mechanism='PLAIN'>%s</auth>", xmppnssasl,
+		base64.StdEncoding.EncodeToString([]byte("\x00"+user+"\x00"+x.password)))
+	if err := c.expect("success"); err != nil {
+		return fail(err)
+	}
+
+	if _, err = c.open(domain); err != nil {
+		return fail(err)
+	}
+	if err := c.bind(); err != nil {
+		return fail(err)
+	}
+	return c, nil
+}
+
+func (x *xmppnotifier) notify(msgs []string) error {
+	c, err := x.connect()
+	if err != nil {
+		return err
+	}
+	defer c.conn.Close()
+
+	for _, room := range x.rooms {
+		if err := c.join(room, x.nick); err != nil {
+			return err
+		}
+		for _, msg := range msgs {
+			if _, err := fmt.Fprintf(c.conn, "<message to='%s' type='groupchat'><body>%s</body></message>", xmlescape(room), xmlescape(msg)); err != nil {
+				return err
+			}
+		}
+	}
+	_, err = io.WriteString(c.conn, "</stream:stream>")
+	return err
+}